@@ -1,7 +1,6 @@
 package apiserver
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -9,26 +8,40 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/mlctrez/vhugo/devicedb"
 	"github.com/mlctrez/vhugo/hlog"
+	"github.com/mlctrez/vhugo/lightstate"
 	"github.com/mlctrez/vhugo/natsserver"
-	"github.com/mlctrez/vhugo/tmpl"
+	"github.com/mlctrez/vhugo/network"
+	"github.com/mlctrez/vhugo/ssdp"
 	"github.com/mlctrez/web"
+	"golang.org/x/net/ipv4"
 )
 
 type ApiServer struct {
 	DB          *devicedb.DeviceDB
 	DeviceGroup *devicedb.DeviceGroup
 	NS          *natsserver.NatsServer
+	Lights      *lightstate.Service
+	// Interfaces are the network interfaces discovery is answered on. With more than one the api is
+	// served on every local address instead of only the device group ip.
+	Interfaces []*network.Interface
+	// SSDPAddress is the multicast address the server is announced on when it starts and stops,
+	// no announcements are sent when empty.
+	SSDPAddress string
 	logger      *hlog.HLog
+	router      *web.Router
 }
 
-func New(db *devicedb.DeviceDB, dg *devicedb.DeviceGroup, ns *natsserver.NatsServer, logger *log.Logger) *ApiServer {
-	return &ApiServer{
+func New(db *devicedb.DeviceDB, dg *devicedb.DeviceGroup, ns *natsserver.NatsServer, lights *lightstate.Service, logger *log.Logger) *ApiServer {
+	a := &ApiServer{
 		DB: db, DeviceGroup: dg,
-		NS: ns, logger: hlog.New(logger, fmt.Sprintf("ApiServer-%d", dg.ServerPort)),
+		NS: ns, Lights: lights, logger: hlog.New(logger, fmt.Sprintf("ApiServer-%d", dg.ServerPort)),
 	}
+	a.router = a.routes()
+	return a
 }
 
 type ApiContext struct {
@@ -43,101 +56,292 @@ func (c *ApiContext) Setup(rw web.ResponseWriter, req *web.Request) {
 		return
 	}
 
+	// the setup xml names the address the request came in on, which the requester can reach
+	dg := c.server.DeviceGroup
+	if localAddr, ok := req.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr); ok {
+		dg = c.server.reachableAt(localAddr.IP.String())
+	}
+
 	// TODO: correct content type here?
-	if settings, err := c.server.DeviceGroup.Setup(); err == nil {
+	if settings, err := dg.Setup(); err == nil {
 		rw.Write(settings)
 	} else {
 		rw.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+func (c *ApiContext) NotFound(rw web.ResponseWriter, req *web.Request) {
+	writeError(rw, ErrResourceNotAvailable, req.URL.Path, req.URL.Path)
+}
+
 func (c *ApiContext) Lights(rw web.ResponseWriter, req *web.Request) {
 	virtualLights, err := c.server.DB.GetVirtualLights(c.server.DeviceGroup.GroupID)
 	if err != nil {
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, ErrInternal, "/lights", err)
 		return
 	}
-	json.NewEncoder(rw).Encode(virtualLights)
+	writeJSON(rw, virtualLights)
 }
 
-func (c *ApiContext) Light(rw web.ResponseWriter, req *web.Request) {
+// SearchLights starts a search for new lights, the lights added since the previous search are found immediately.
+func (c *ApiContext) SearchLights(rw web.ResponseWriter, req *web.Request) {
+	if err := c.server.DB.StartLightScan(c.server.DeviceGroup.GroupID); err != nil {
+		writeError(rw, ErrInternal, "/lights", err)
+		return
+	}
+	writeJSON(rw, []HueResponse{SuccessValue("/lights", "Searching for new devices")})
+}
 
-	lightID := req.PathParams["lightID"]
-	if lightID == "" {
-		rw.WriteHeader(http.StatusNotFound)
+// NewLights lists the lights found by the last search with the time it started as lastscan.
+func (c *ApiContext) NewLights(rw web.ResponseWriter, req *web.Request) {
+	groupID := c.server.DeviceGroup.GroupID
+
+	ls, err := c.server.DB.GetLightScan(groupID)
+	if err != nil {
+		writeError(rw, ErrInternal, "/lights/new", err)
 		return
 	}
+	virtualLights, err := c.server.DB.GetVirtualLights(groupID)
+	if err != nil {
+		writeError(rw, ErrInternal, "/lights/new", err)
+		return
+	}
+
+	newLights := make(map[string]interface{})
+	for _, lightID := range ls.Lights {
+		// lights deleted since the search are no longer reported
+		if vl, ok := virtualLights[lightID]; ok {
+			newLights[lightID] = map[string]string{"name": vl.Name}
+		}
+	}
+	newLights["lastscan"] = ls.LastScan(time.Now())
+	writeJSON(rw, newLights)
+}
+
+func (c *ApiContext) Light(rw web.ResponseWriter, req *web.Request) {
+
+	lightID := req.PathParams["lightID"]
+	address := "/lights/" + lightID
+
 	virtualLight, err := c.server.DB.GetVirtualLight(c.server.DeviceGroup.GroupID, lightID)
 	if err != nil {
 		if strings.Contains(err.Error(), "does not exist") {
-			rw.WriteHeader(http.StatusNotFound)
+			writeError(rw, ErrResourceNotAvailable, address, address)
 			return
 		}
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeError(rw, ErrInternal, address, err)
 		return
 	}
-	json.NewEncoder(rw).Encode(virtualLight)
+	writeJSON(rw, virtualLight)
 
 }
 
 func (c *ApiContext) LightState(rw web.ResponseWriter, req *web.Request) {
 	lightID := req.PathParams["lightID"]
-	if lightID == "" {
-		rw.WriteHeader(http.StatusNotFound)
+	address := "/lights/" + lightID
+
+	sr := &devicedb.StateRequest{}
+	if err := json.NewDecoder(req.Body).Decode(sr); err != nil {
+		writeError(rw, ErrInvalidJSON, address+"/state")
+		return
+	}
+	invalid := sr.Invalid()
+	if sr.Empty() && len(invalid) == 0 {
+		writeError(rw, ErrMissingParameters, address+"/state")
 		return
 	}
 
-	groupID := c.server.DeviceGroup.GroupID
+	response := make([]HueResponse, 0)
+	for _, f := range invalid {
+		response = append(response, Error(NewHueError(ErrInvalidValue, fmt.Sprintf("%s/state/%s", address, f.Name), f.Value, f.Name)))
+	}
+	if sr.Empty() {
+		writeJSON(rw, response)
+		return
+	}
 
-	virtualLight, err := c.server.DB.GetVirtualLight(groupID, lightID)
+	virtualLight, err := c.server.changeLightState(lightID, sr, origin(req))
 	if err != nil {
-		if strings.Contains(err.Error(), "does not exist") {
-			rw.WriteHeader(http.StatusNotFound)
-			return
-		}
-		rw.WriteHeader(http.StatusInternalServerError)
+		writeJSON(rw, append(response, Error(lightStateError(lightID, err))))
 		return
 	}
-	sr := &devicedb.StateRequest{}
-	json.NewDecoder(req.Body).Decode(sr)
 
-	virtualLight.UpdateState(sr)
+	profile := virtualLight.Profile()
+	for _, f := range sr.Applied(virtualLight.State) {
+		fieldAddress := fmt.Sprintf("%s/state/%s", address, f.Name)
+		if !profile.Supports(f.Name) {
+			response = append(response, Error(NewHueError(ErrParameterNotAvailable, fieldAddress, f.Name)))
+			continue
+		}
+		response = append(response, SuccessValue(fieldAddress, f.Value))
+	}
+	writeJSON(rw, response)
 
-	ch := make(map[string]interface{})
-	ch["groupID"] = groupID
-	ch["lightID"] = lightID
-	ch["stateRequest"] = sr
+}
 
-	c.server.NS.Publish("lightStateChange", ch)
+// sourceKey is the request context key of the source of commands run by Execute.
+type sourceKey struct{}
 
-	err = c.server.DB.UpdateVirtualLight(groupID, virtualLight)
-	if err != nil {
-		rw.WriteHeader(http.StatusInternalServerError)
-		return
+// origin tells the light state service who sent the request, a hue client unless Execute ran it.
+func origin(req *web.Request) lightstate.Origin {
+	if source, ok := req.Context().Value(sourceKey{}).(string); ok {
+		return lightstate.Origin{Source: source}
 	}
+	return lightstate.Origin{Source: lightstate.SourceHueAPI, RemoteAddr: req.RemoteAddr}
+}
 
-	json.NewEncoder(rw).Encode(virtualLight)
+// changeLightState changes the state of a light of this device group through the light state service.
+func (a *ApiServer) changeLightState(lightID string, sr *devicedb.StateRequest, o lightstate.Origin) (*devicedb.VirtualLight, error) {
+	return a.Lights.Change(a.DeviceGroup.GroupID, lightID, sr, o)
+}
 
+// lightStateError is the hue error of a failed state change of a light. A light that did not
+// acknowledge the change is reported as a device that cannot be modified.
+func lightStateError(lightID string, err error) *HueError {
+	address := "/lights/" + lightID
+	switch {
+	case strings.Contains(err.Error(), "does not exist"):
+		return NewHueError(ErrResourceNotAvailable, address, address)
+	case strings.Contains(err.Error(), "unreachable"):
+		return &HueError{Type: ErrDeviceOff, Address: address + "/state",
+			Description: "parameter, state, is not modifiable. Device is unreachable."}
+	}
+	return NewHueError(ErrInternal, address+"/state", err)
 }
 
-func (c *ApiContext) DeleteLight(rw web.ResponseWriter, req *web.Request) {
+type LightRequest struct {
+	Name        *string           `json:"name"`
+	Pointsymbol map[string]string `json:"pointsymbol"`
+}
+
+// UpdateLight changes the name or point symbols of a light, the light keeps its id and state.
+func (c *ApiContext) UpdateLight(rw web.ResponseWriter, req *web.Request) {
 	lightID := req.PathParams["lightID"]
-	if lightID == "" {
-		rw.WriteHeader(http.StatusNotFound)
+	address := "/lights/" + lightID
+	groupID := c.server.DeviceGroup.GroupID
+
+	lr := &LightRequest{}
+	if err := json.NewDecoder(req.Body).Decode(lr); err != nil {
+		writeError(rw, ErrInvalidJSON, address)
+		return
+	}
+	if lr.Name == nil && lr.Pointsymbol == nil {
+		writeError(rw, ErrMissingParameters, address)
+		return
+	}
+	if lr.Name != nil && !devicedb.ValidLightName(*lr.Name) {
+		writeError(rw, ErrInvalidValue, address+"/name", *lr.Name, "name")
+		return
+	}
+
+	response := make([]HueResponse, 0)
+	_, err := c.server.Lights.Edit(groupID, lightID, func(vl *devicedb.VirtualLight) error {
+		if lr.Name != nil {
+			vl.Name = *lr.Name
+			response = append(response, SuccessValue(address+"/name", vl.Name))
+		}
+		for symbol, value := range lr.Pointsymbol {
+			if _, ok := vl.Pointsymbol[symbol]; !ok {
+				return fmt.Errorf("point symbol %s does not exist", symbol)
+			}
+			vl.Pointsymbol[symbol] = value
+			response = append(response, SuccessValue(address+"/pointsymbol/"+symbol, value))
+		}
+		return nil
+	})
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "point symbol"):
+			writeError(rw, ErrParameterNotAvailable, address+"/pointsymbol", "pointsymbol")
+		case strings.Contains(err.Error(), "does not exist"):
+			writeError(rw, ErrResourceNotAvailable, address, address)
+		default:
+			writeError(rw, ErrInternal, address, err)
+		}
 		return
 	}
+
+	writeJSON(rw, response)
+}
+
+func (c *ApiContext) DeleteLight(rw web.ResponseWriter, req *web.Request) {
+	lightID := req.PathParams["lightID"]
+	address := "/lights/" + lightID
 	groupID := c.server.DeviceGroup.GroupID
-	err := c.server.DB.DeleteVirtualLight(groupID, lightID)
+	err := c.server.Lights.Delete(groupID, lightID)
 	if err != nil {
-		rw.WriteHeader(http.StatusInternalServerError)
+		if strings.Contains(err.Error(), "does not exist") {
+			writeError(rw, ErrResourceNotAvailable, address, address)
+			return
+		}
+		writeError(rw, ErrInternal, address, err)
 		return
 	}
+	writeJSON(rw, []HueResponse{Success(address + " deleted")})
 }
 
-func (a *ApiServer) Run(ctx context.Context) {
+func (a *ApiServer) routes() *web.Router {
 
 	router := web.New(ApiContext{})
 
+	router.Middleware(a.logger.LoggerMiddleware)
+
+	router.Middleware(func(ctx *ApiContext, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+		ctx.server = a
+		next(rw, req)
+	})
+
+	router.NotFound((*ApiContext).NotFound)
+
+	router.Get("/api/upnp/:groupID/setup.xml", (*ApiContext).Setup)
+	router.Post("/api", (*ApiContext).CreateUser)
+	router.Get("/api/:userID/config", (*ApiContext).Config)
+
+	userRouter := router.Subrouter(ApiContext{}, "/api/:userID")
+	userRouter.Middleware((*ApiContext).Authorize)
+	userRouter.Get("", (*ApiContext).FullState)
+	userRouter.Get("/lights", (*ApiContext).Lights)
+	userRouter.Post("/lights", (*ApiContext).SearchLights)
+	userRouter.Get("/lights/new", (*ApiContext).NewLights)
+	userRouter.Get("/lights/:lightID", (*ApiContext).Light)
+	userRouter.Put("/lights/:lightID", (*ApiContext).UpdateLight)
+	userRouter.Put("/lights/:lightID/state", (*ApiContext).LightState)
+	userRouter.Delete("/lights/:lightID", (*ApiContext).DeleteLight)
+	userRouter.Get("/groups", (*ApiContext).Groups)
+	userRouter.Post("/groups", (*ApiContext).CreateGroup)
+	userRouter.Get("/groups/:hueGroupID", (*ApiContext).Group)
+	userRouter.Put("/groups/:hueGroupID", (*ApiContext).UpdateGroup)
+	userRouter.Delete("/groups/:hueGroupID", (*ApiContext).DeleteGroup)
+	userRouter.Put("/groups/:hueGroupID/action", (*ApiContext).GroupAction)
+	userRouter.Get("/scenes", (*ApiContext).Scenes)
+	userRouter.Post("/scenes", (*ApiContext).CreateScene)
+	userRouter.Get("/scenes/:sceneID", (*ApiContext).Scene)
+	userRouter.Put("/scenes/:sceneID", (*ApiContext).UpdateScene)
+	userRouter.Put("/scenes/:sceneID/lightstates/:lightID", (*ApiContext).SceneLightState)
+	userRouter.Delete("/scenes/:sceneID", (*ApiContext).DeleteScene)
+	userRouter.Get("/schedules", (*ApiContext).Schedules)
+	userRouter.Post("/schedules", (*ApiContext).CreateSchedule)
+	userRouter.Get("/schedules/:scheduleID", (*ApiContext).Schedule)
+	userRouter.Put("/schedules/:scheduleID", (*ApiContext).UpdateSchedule)
+	userRouter.Delete("/schedules/:scheduleID", (*ApiContext).DeleteSchedule)
+	userRouter.Get("/sensors", (*ApiContext).Sensors)
+	userRouter.Post("/sensors", (*ApiContext).CreateSensor)
+	userRouter.Get("/sensors/:sensorID", (*ApiContext).Sensor)
+	userRouter.Put("/sensors/:sensorID", (*ApiContext).UpdateSensor)
+	userRouter.Delete("/sensors/:sensorID", (*ApiContext).DeleteSensor)
+	userRouter.Put("/sensors/:sensorID/state", (*ApiContext).SensorState)
+	userRouter.Put("/sensors/:sensorID/config", (*ApiContext).SensorConfig)
+	userRouter.Get("/rules", (*ApiContext).Rules)
+	userRouter.Post("/rules", (*ApiContext).CreateRule)
+	userRouter.Get("/rules/:ruleID", (*ApiContext).Rule)
+	userRouter.Put("/rules/:ruleID", (*ApiContext).UpdateRule)
+	userRouter.Delete("/rules/:ruleID", (*ApiContext).DeleteRule)
+
+	return router
+}
+
+func (a *ApiServer) Run(ctx context.Context) {
+
 	apiServerContext, cancel := context.WithCancel(ctx)
 
 	subscription, err := a.NS.Subscribe("upnp.discovery", a.HandleDiscoveryRequest)
@@ -148,55 +352,160 @@ func (a *ApiServer) Run(ctx context.Context) {
 	}
 	defer subscription.Unsubscribe()
 
-	router.Middleware(a.logger.LoggerMiddleware)
-
-	router.Middleware(func(ctx *ApiContext, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
-		ctx.server = a
-		next(rw, req)
-	})
-
-	router.Get("/api/upnp/:groupID/setup.xml", (*ApiContext).Setup)
-	router.Get("/api/:userID/lights", (*ApiContext).Lights)
-	router.Get("/api/:userID/lights/:lightID", (*ApiContext).Light)
-	router.Put("/api/:userID/lights/:lightID/state", (*ApiContext).LightState)
-	router.Delete("/api/:userID/lights/:lightID", (*ApiContext).DeleteLight)
+	reportSubscription, err := a.NS.Subscribe("lightStateReport", a.HandleStateReport)
+	if err != nil {
+		a.logger.Println("Run Subscribe", err)
+		cancel()
+		return
+	}
+	defer reportSubscription.Unsubscribe()
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", a.DeviceGroup.ServerIP, a.DeviceGroup.ServerPort),
-		Handler: router,
+		Handler: a.router,
+	}
+	if a.listenAll() {
+		server.Addr = fmt.Sprintf(":%d", a.DeviceGroup.ServerPort)
+	}
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		a.logger.Println("Listen", err)
+		cancel()
+		return
 	}
 
 	go func() {
-		err := server.ListenAndServe()
+		err := server.Serve(listener)
 		if err != http.ErrServerClosed {
-			a.logger.Println("ListenAndServe", err)
+			a.logger.Println("Serve", err)
 		}
-		a.logger.Println("ListenAndServe exited")
+		a.logger.Println("Serve exited")
 		cancel()
 	}()
-	<-apiServerContext.Done()
-	a.logger.Println("apiServerContext.Done()")
-	server.Shutdown(ctx)
-	return
+	a.Notify(ssdp.Alive)
+	announce := time.NewTicker(ssdp.AnnounceInterval)
+	defer announce.Stop()
+	for {
+		select {
+		case <-announce.C:
+			a.Notify(ssdp.Alive)
+		case <-apiServerContext.Done():
+			a.logger.Println("apiServerContext.Done()")
+			a.Notify(ssdp.ByeBye)
+			server.Shutdown(ctx)
+			return
+		}
+	}
 }
 
-func (a *ApiServer) HandleDiscoveryRequest(d *DiscoveryRequest) {
-	if addr, err := net.ResolveUDPAddr("udp4", d.Remote); err == nil {
-		if con, err := net.DialUDP("udp4", nil, addr); err == nil {
-			defer con.Close()
+// listenAll is true on a multi-homed host, where requesters on every interface must reach the api.
+func (a *ApiServer) listenAll() bool {
+	return len(a.Interfaces) > 1
+}
 
-			b := &bytes.Buffer{}
-			tmpl.DisoveryResponseTemplate.Execute(b, a.DeviceGroup)
-			a.NS.Publish("upnp.response", &DiscoveryResponse{Remote: d.Remote, Packet: string(b.Bytes())})
+// reachableAt is the device group with ip as the server ip, for the LOCATION and URLBase of a requester
+// on another network of a multi-homed host. The device group is returned as is when ip is empty or
+// the server does not listen on every address.
+func (a *ApiServer) reachableAt(ip string) *devicedb.DeviceGroup {
+	if ip == "" || !a.listenAll() || ip == a.DeviceGroup.ServerIP {
+		return a.DeviceGroup
+	}
+	dg := *a.DeviceGroup
+	dg.ServerIP = ip
+	return &dg
+}
+
+// device is the upnp root device of the device group as seen from ip.
+func (a *ApiServer) device(ip string) *ssdp.Device {
+	dg := a.reachableAt(ip)
+	return &ssdp.Device{
+		UUID:     dg.UUID,
+		Location: fmt.Sprintf("http://%s:%d/api/upnp/%s/setup.xml", dg.ServerIP, dg.ServerPort, dg.GroupID),
+		BridgeID: dg.BridgeID(),
+	}
+}
 
-			con.Write(b.Bytes())
+// Notify multicasts the alive or byebye announcements of every target of the device group, on each
+// interface of a multi-homed host with the LOCATION on that interface.
+func (a *ApiServer) Notify(nts string) {
+	if a.SSDPAddress == "" {
+		return
+	}
+	addr, err := net.ResolveUDPAddr("udp4", a.SSDPAddress)
+	if err != nil {
+		a.logger.Println("Notify ResolveUDPAddr", err)
+		return
+	}
+	con, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		a.logger.Println("Notify ListenUDP", err)
+		return
+	}
+	defer con.Close()
+
+	send := func(device *ssdp.Device) {
+		for _, t := range device.Targets() {
+			if _, err := con.WriteTo(device.Notify(a.SSDPAddress, t, nts), addr); err != nil {
+				a.logger.Println("Notify WriteTo", err)
+				return
+			}
 		}
 	}
+	if !a.listenAll() {
+		send(a.device(""))
+		return
+	}
+	pc := ipv4.NewPacketConn(con)
+	for _, i := range a.Interfaces {
+		if err = pc.SetMulticastInterface(i.Interface); err != nil {
+			a.logger.Println("Notify SetMulticastInterface", i.Name, err)
+			continue
+		}
+		send(a.device(i.IP().String()))
+	}
+}
+
+// HandleDiscoveryRequest answers a search with the matching targets of the device group, each
+// after a random delay within the MX of the search.
+func (a *ApiServer) HandleDiscoveryRequest(d *DiscoveryRequest) {
+	addr, err := net.ResolveUDPAddr("udp4", d.Remote)
+	if err != nil {
+		return
+	}
+	device := a.device(d.LocalIP)
+	for _, t := range device.Matching(d.ST) {
+		response := device.Response(t)
+		time.AfterFunc(ssdp.Delay(d.MX), func() {
+			if con, err := net.DialUDP("udp4", nil, addr); err == nil {
+				defer con.Close()
+				a.NS.Publish("upnp.response", &DiscoveryResponse{Remote: d.Remote, Packet: string(response)})
+				con.Write(response)
+			}
+		})
+	}
 }
 
+// HandleStateReport stores the state a light of this device group reports and publishes the stored
+// state on lightStateChange with the device source.
+func (a *ApiServer) HandleStateReport(report *devicedb.LightStateReport) {
+	if report.GroupID != a.DeviceGroup.GroupID || report.StateRequest == nil {
+		return
+	}
+	if _, err := a.Lights.Report(report.GroupID, report.LightID, report.StateRequest); err != nil {
+		a.logger.Println("HandleStateReport", report.LightID, err)
+	}
+}
+
+// DiscoveryRequest is an ssdp search read by the upnp listener. LocalIP is the address of the
+// interface the search came in on that is on the network of the requester.
 type DiscoveryRequest struct {
-	Remote string
-	Packet string
+	Remote  string
+	Packet  string
+	LocalIP string
+	// ST is the search target and MX the seconds the responses are spread over.
+	ST string
+	MX int
 }
 
 type DiscoveryResponse struct {
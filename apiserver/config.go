@@ -0,0 +1,151 @@
+package apiserver
+
+import (
+	"time"
+
+	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/web"
+)
+
+const (
+	ApiVersion       = "1.16.0"
+	SwVersion        = "1709131301"
+	ModelID          = "BSB002"
+	DatastoreVersion = "59"
+)
+
+// ShortConfig is the subset of the bridge configuration available without a whitelisted user.
+type ShortConfig struct {
+	Name             string      `json:"name"`
+	DatastoreVersion string      `json:"datastoreversion"`
+	SwVersion        string      `json:"swversion"`
+	ApiVersion       string      `json:"apiversion"`
+	Mac              string      `json:"mac"`
+	BridgeID         string      `json:"bridgeid"`
+	FactoryNew       bool        `json:"factorynew"`
+	ReplacesBridgeID interface{} `json:"replacesbridgeid"`
+	ModelID          string      `json:"modelid"`
+	StarterKitID     string      `json:"starterkitid"`
+}
+
+type Config struct {
+	ShortConfig
+	ZigbeeChannel    int                                `json:"zigbeechannel"`
+	Dhcp             bool                               `json:"dhcp"`
+	IPAddress        string                             `json:"ipaddress"`
+	Netmask          string                             `json:"netmask"`
+	Gateway          string                             `json:"gateway"`
+	ProxyAddress     string                             `json:"proxyaddress"`
+	ProxyPort        int                                `json:"proxyport"`
+	UTC              string                             `json:"UTC"`
+	LocalTime        string                             `json:"localtime"`
+	TimeZone         string                             `json:"timezone"`
+	LinkButton       bool                               `json:"linkbutton"`
+	PortalServices   bool                               `json:"portalservices"`
+	PortalConnection string                             `json:"portalconnection"`
+	Whitelist        map[string]*devicedb.WhitelistUser `json:"whitelist"`
+}
+
+// FullState is the response to GET /api/:userID with every resource of the bridge.
+type FullState struct {
+	Lights        map[string]*devicedb.VirtualLight `json:"lights"`
+	Groups        map[string]*devicedb.HueGroup     `json:"groups"`
+	Config        *Config                           `json:"config"`
+	Schedules     map[string]*devicedb.Schedule     `json:"schedules"`
+	Scenes        map[string]*devicedb.Scene        `json:"scenes"`
+	Rules         map[string]*devicedb.Rule         `json:"rules"`
+	Sensors       map[string]*devicedb.Sensor       `json:"sensors"`
+	ResourceLinks map[string]interface{}            `json:"resourcelinks"`
+}
+
+func (a *ApiServer) shortConfig() ShortConfig {
+	dg := a.DeviceGroup
+	return ShortConfig{
+		Name:             dg.Name(),
+		DatastoreVersion: DatastoreVersion,
+		SwVersion:        SwVersion,
+		ApiVersion:       ApiVersion,
+		Mac:              dg.Mac(),
+		BridgeID:         dg.BridgeID(),
+		ModelID:          ModelID,
+	}
+}
+
+func (a *ApiServer) config() (config *Config, err error) {
+	config = &Config{
+		ShortConfig:      a.shortConfig(),
+		Dhcp:             true,
+		IPAddress:        a.DeviceGroup.ServerIP,
+		Netmask:          "255.255.255.0",
+		Gateway:          a.DeviceGroup.ServerIP,
+		ProxyAddress:     "none",
+		PortalConnection: "disconnected",
+		ZigbeeChannel:    15,
+	}
+
+	now := time.Now()
+	config.UTC = now.UTC().Format(devicedb.HueTime)
+	config.LocalTime = now.Format(devicedb.HueTime)
+	config.TimeZone = now.Location().String()
+
+	if config.LinkButton, err = a.DB.LinkButtonPressed(); err != nil {
+		return
+	}
+	config.Whitelist, err = a.DB.GetWhitelist()
+	return
+}
+
+// Config answers with the full configuration for whitelisted users and the short configuration otherwise.
+func (c *ApiContext) Config(rw web.ResponseWriter, req *web.Request) {
+	user, err := c.server.DB.GetWhitelistUser(req.PathParams["userID"])
+	if err != nil {
+		writeJSON(rw, c.server.shortConfig())
+		return
+	}
+	c.server.recordUse(user)
+	config, err := c.server.config()
+	if err != nil {
+		writeError(rw, ErrInternal, "/config", err)
+		return
+	}
+	writeJSON(rw, config)
+}
+
+func (c *ApiContext) FullState(rw web.ResponseWriter, req *web.Request) {
+	fs := &FullState{
+		ResourceLinks: map[string]interface{}{},
+	}
+	var err error
+	if fs.Config, err = c.server.config(); err != nil {
+		writeError(rw, ErrInternal, "/", err)
+		return
+	}
+	if fs.Lights, err = c.server.DB.GetVirtualLights(c.server.DeviceGroup.GroupID); err != nil {
+		writeError(rw, ErrInternal, "/", err)
+		return
+	}
+	if fs.Groups, err = c.server.hueGroups(); err != nil {
+		writeError(rw, ErrInternal, "/", err)
+		return
+	}
+	if fs.Scenes, err = c.server.DB.GetScenes(c.server.DeviceGroup.GroupID); err != nil {
+		writeError(rw, ErrInternal, "/", err)
+		return
+	}
+	for _, scene := range fs.Scenes {
+		scene.LightStates = nil
+	}
+	if fs.Schedules, err = c.server.DB.GetSchedules(c.server.DeviceGroup.GroupID); err != nil {
+		writeError(rw, ErrInternal, "/", err)
+		return
+	}
+	if fs.Sensors, err = c.server.DB.GetSensors(c.server.DeviceGroup.GroupID); err != nil {
+		writeError(rw, ErrInternal, "/", err)
+		return
+	}
+	if fs.Rules, err = c.server.DB.GetRules(c.server.DeviceGroup.GroupID); err != nil {
+		writeError(rw, ErrInternal, "/", err)
+		return
+	}
+	writeJSON(rw, fs)
+}
@@ -0,0 +1,294 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/web"
+)
+
+type GroupRequest struct {
+	Name   *string  `json:"name"`
+	Lights []string `json:"lights"`
+	Type   *string  `json:"type"`
+	Class  *string  `json:"class"`
+}
+
+func (c *ApiContext) Groups(rw web.ResponseWriter, req *web.Request) {
+	groups, err := c.server.hueGroups()
+	if err != nil {
+		writeError(rw, ErrInternal, "/groups", err)
+		return
+	}
+	writeJSON(rw, groups)
+}
+
+// hueGroups returns the stored hue groups with their all_on and any_on state computed from the lights.
+func (a *ApiServer) hueGroups() (groups map[string]*devicedb.HueGroup, err error) {
+	groupID := a.DeviceGroup.GroupID
+	if groups, err = a.DB.GetHueGroups(groupID); err != nil {
+		return
+	}
+	lights, err := a.DB.GetVirtualLights(groupID)
+	if err != nil {
+		return
+	}
+	for _, hg := range groups {
+		hg.UpdateGroupState(lights)
+	}
+	return
+}
+
+func (c *ApiContext) Group(rw web.ResponseWriter, req *web.Request) {
+	hueGroupID := req.PathParams["hueGroupID"]
+	address := "/groups/" + hueGroupID
+
+	hg, err := c.server.hueGroup(hueGroupID)
+	if err != nil {
+		writeGroupError(rw, address, err)
+		return
+	}
+	writeJSON(rw, hg)
+}
+
+func (a *ApiServer) hueGroup(hueGroupID string) (hg *devicedb.HueGroup, err error) {
+	groupID := a.DeviceGroup.GroupID
+	if hg, err = a.DB.GetHueGroup(groupID, hueGroupID); err != nil {
+		return
+	}
+	lights, err := a.DB.GetVirtualLights(groupID)
+	if err != nil {
+		return
+	}
+	hg.UpdateGroupState(lights)
+	return
+}
+
+func writeGroupError(rw web.ResponseWriter, address string, err error) {
+	if strings.Contains(err.Error(), "does not exist") {
+		writeError(rw, ErrResourceNotAvailable, address, address)
+		return
+	}
+	writeError(rw, ErrInternal, address, err)
+}
+
+// invalidLight returns the first of lightIDs that is not a light in this device group.
+func (a *ApiServer) invalidLight(lightIDs []string) (invalid string, err error) {
+	lights, err := a.DB.GetVirtualLights(a.DeviceGroup.GroupID)
+	if err != nil {
+		return
+	}
+	for _, lightID := range lightIDs {
+		if _, ok := lights[lightID]; !ok {
+			return lightID, nil
+		}
+	}
+	return
+}
+
+func (c *ApiContext) CreateGroup(rw web.ResponseWriter, req *web.Request) {
+	gr := &GroupRequest{}
+	if err := json.NewDecoder(req.Body).Decode(gr); err != nil {
+		writeError(rw, ErrInvalidJSON, "/groups")
+		return
+	}
+	if gr.Lights == nil {
+		writeError(rw, ErrMissingParameters, "/groups")
+		return
+	}
+
+	groupType := "LightGroup"
+	if gr.Type != nil {
+		groupType = *gr.Type
+	}
+	if groupType != "LightGroup" && groupType != "Room" {
+		writeError(rw, ErrInvalidValue, "/groups/type", groupType, "type")
+		return
+	}
+
+	if gr.Class != nil && !devicedb.ValidRoomClass(*gr.Class) {
+		writeError(rw, ErrInvalidValue, "/groups/class", *gr.Class, "class")
+		return
+	}
+
+	if invalid, err := c.server.invalidLight(gr.Lights); err != nil {
+		writeError(rw, ErrInternal, "/groups", err)
+		return
+	} else if invalid != "" {
+		writeError(rw, ErrInvalidValue, "/groups/lights", invalid, "lights")
+		return
+	}
+
+	hg := devicedb.NewHueGroup("", groupType, gr.Lights)
+	if gr.Class != nil {
+		hg.Class = *gr.Class
+	}
+	if gr.Name != nil {
+		hg.Name = *gr.Name
+	}
+
+	hueGroupID, err := c.server.DB.AddHueGroup(c.server.DeviceGroup.GroupID, hg)
+	if err != nil {
+		writeError(rw, ErrInternal, "/groups", err)
+		return
+	}
+	writeJSON(rw, []HueResponse{Success(map[string]string{"id": hueGroupID})})
+}
+
+func (c *ApiContext) UpdateGroup(rw web.ResponseWriter, req *web.Request) {
+	hueGroupID := req.PathParams["hueGroupID"]
+	address := "/groups/" + hueGroupID
+
+	if hueGroupID == devicedb.AllLightsGroup {
+		writeError(rw, ErrParameterNotModifiable, address, address)
+		return
+	}
+
+	gr := &GroupRequest{}
+	if err := json.NewDecoder(req.Body).Decode(gr); err != nil {
+		writeError(rw, ErrInvalidJSON, address)
+		return
+	}
+
+	hg, err := c.server.DB.GetHueGroup(c.server.DeviceGroup.GroupID, hueGroupID)
+	if err != nil {
+		writeGroupError(rw, address, err)
+		return
+	}
+
+	response := make([]HueResponse, 0)
+	if gr.Name != nil {
+		hg.Name = *gr.Name
+		response = append(response, SuccessValue(address+"/name", hg.Name))
+	}
+	if gr.Lights != nil {
+		if invalid, err := c.server.invalidLight(gr.Lights); err != nil {
+			writeError(rw, ErrInternal, address, err)
+			return
+		} else if invalid != "" {
+			writeError(rw, ErrInvalidValue, address+"/lights", invalid, "lights")
+			return
+		}
+		hg.Lights = gr.Lights
+		response = append(response, SuccessValue(address+"/lights", hg.Lights))
+	}
+	if gr.Class != nil {
+		if !devicedb.ValidRoomClass(*gr.Class) {
+			writeError(rw, ErrInvalidValue, address+"/class", *gr.Class, "class")
+			return
+		}
+		hg.Class = *gr.Class
+		response = append(response, SuccessValue(address+"/class", hg.Class))
+	}
+	if len(response) == 0 {
+		writeError(rw, ErrMissingParameters, address)
+		return
+	}
+
+	if err = c.server.DB.UpdateHueGroup(c.server.DeviceGroup.GroupID, hueGroupID, hg); err != nil {
+		writeError(rw, ErrInternal, address, err)
+		return
+	}
+	writeJSON(rw, response)
+}
+
+func (c *ApiContext) DeleteGroup(rw web.ResponseWriter, req *web.Request) {
+	hueGroupID := req.PathParams["hueGroupID"]
+	address := "/groups/" + hueGroupID
+
+	if hueGroupID == devicedb.AllLightsGroup {
+		writeError(rw, ErrParameterNotModifiable, address, address)
+		return
+	}
+	if err := c.server.DB.DeleteHueGroup(c.server.DeviceGroup.GroupID, hueGroupID); err != nil {
+		writeGroupError(rw, address, err)
+		return
+	}
+	writeJSON(rw, []HueResponse{Success(address + " deleted")})
+}
+
+// GroupActionRequest is a state request for the group members, optionally recalling a scene.
+type GroupActionRequest struct {
+	devicedb.StateRequest
+	Scene *string `json:"scene"`
+}
+
+// GroupAction applies the state request to every light of the group, one light state change per member.
+func (c *ApiContext) GroupAction(rw web.ResponseWriter, req *web.Request) {
+	hueGroupID := req.PathParams["hueGroupID"]
+	address := "/groups/" + hueGroupID
+
+	ga := &GroupActionRequest{}
+	if err := json.NewDecoder(req.Body).Decode(ga); err != nil {
+		writeError(rw, ErrInvalidJSON, address+"/action")
+		return
+	}
+	sr := &ga.StateRequest
+	invalid := sr.Invalid()
+	if sr.Empty() && ga.Scene == nil && len(invalid) == 0 {
+		writeError(rw, ErrMissingParameters, address+"/action")
+		return
+	}
+
+	hg, err := c.server.DB.GetHueGroup(c.server.DeviceGroup.GroupID, hueGroupID)
+	if err != nil {
+		writeGroupError(rw, address, err)
+		return
+	}
+
+	response := make([]HueResponse, 0)
+	for _, f := range invalid {
+		response = append(response, Error(NewHueError(ErrInvalidValue, fmt.Sprintf("%s/action/%s", address, f.Name), f.Value, f.Name)))
+	}
+	if ga.Scene != nil {
+		failed, err := c.server.recallScene(hg, *ga.Scene, origin(req))
+		if err != nil {
+			if strings.Contains(err.Error(), "does not exist") {
+				writeError(rw, ErrInvalidValue, address+"/action/scene", *ga.Scene, "scene")
+				return
+			}
+			writeError(rw, ErrInternal, address+"/action", err)
+			return
+		}
+		for _, he := range failed {
+			response = append(response, Error(he))
+		}
+		response = append(response, SuccessValue(address+"/action/scene", *ga.Scene))
+	}
+
+	if !sr.Empty() {
+		changed := 0
+		for _, lightID := range hg.Lights {
+			if _, err := c.server.changeLightState(lightID, sr, origin(req)); err != nil {
+				c.server.logger.Println("GroupAction", hueGroupID, "light", lightID, err)
+				response = append(response, Error(lightStateError(lightID, err)))
+				continue
+			}
+			changed++
+		}
+
+		// the action only reports success when a light took the change, or the group has no lights
+		if changed > 0 || len(hg.Lights) == 0 {
+			hg.UpdateAction(sr)
+			if err = c.server.DB.UpdateHueGroup(c.server.DeviceGroup.GroupID, hueGroupID, hg); err != nil {
+				writeError(rw, ErrInternal, address+"/action", err)
+				return
+			}
+
+			for _, f := range sr.Applied(hg.Action) {
+				response = append(response, SuccessValue(fmt.Sprintf("%s/action/%s", address, f.Name), f.Value))
+			}
+		}
+	}
+
+	if ga.Scene != nil || !sr.Empty() {
+		scene := ""
+		if ga.Scene != nil {
+			scene = *ga.Scene
+		}
+		c.server.Lights.GroupAction(c.server.DeviceGroup.GroupID, hueGroupID, hg, sr, scene, origin(req))
+	}
+	writeJSON(rw, response)
+}
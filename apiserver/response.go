@@ -0,0 +1,81 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mlctrez/web"
+)
+
+// Hue api error types, see https://developers.meethue.com/develop/hue-api/error-messages/
+const (
+	ErrUnauthorizedUser       = 1
+	ErrInvalidJSON            = 2
+	ErrResourceNotAvailable   = 3
+	ErrMethodNotAvailable     = 4
+	ErrMissingParameters      = 5
+	ErrParameterNotAvailable  = 6
+	ErrInvalidValue           = 7
+	ErrParameterNotModifiable = 8
+	ErrTooManyItems           = 11
+	ErrLinkButtonNotPressed   = 101
+	ErrDeviceOff              = 201
+	ErrGroupTableFull         = 301
+	ErrInternal               = 901
+)
+
+var errorDescriptions = map[int]string{
+	ErrUnauthorizedUser:       "unauthorized user",
+	ErrInvalidJSON:            "body contains invalid json",
+	ErrResourceNotAvailable:   "resource, %s, not available",
+	ErrMethodNotAvailable:     "method, %s, not available for resource, %s",
+	ErrMissingParameters:      "invalid/missing parameters in body",
+	ErrParameterNotAvailable:  "parameter, %s, not available",
+	ErrInvalidValue:           "invalid value, %v, for parameter, %s",
+	ErrParameterNotModifiable: "parameter, %s, is not modifiable",
+	ErrTooManyItems:           "too many items in list",
+	ErrLinkButtonNotPressed:   "link button not pressed",
+	ErrDeviceOff:              "parameter, %s, is not modifiable. Device is set to off.",
+	ErrGroupTableFull:         "group could not be created. Group table is full.",
+	ErrInternal:               "internal error, %v",
+}
+
+type HueError struct {
+	Type        int    `json:"type"`
+	Address     string `json:"address"`
+	Description string `json:"description"`
+}
+
+// NewHueError creates an error of the given type, formatting the standard description with args.
+func NewHueError(errType int, address string, args ...interface{}) *HueError {
+	description := errorDescriptions[errType]
+	if len(args) > 0 {
+		description = fmt.Sprintf(description, args...)
+	}
+	return &HueError{Type: errType, Address: address, Description: description}
+}
+
+// HueResponse is a single entry of the success/error arrays returned by a hue bridge.
+type HueResponse map[string]interface{}
+
+func Success(v interface{}) HueResponse {
+	return HueResponse{"success": v}
+}
+
+func SuccessValue(address string, value interface{}) HueResponse {
+	return Success(map[string]interface{}{address: value})
+}
+
+func Error(he *HueError) HueResponse {
+	return HueResponse{"error": he}
+}
+
+// writeJSON writes v as the json response. A hue bridge always answers with 200, errors included.
+func writeJSON(rw web.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(v)
+}
+
+func writeError(rw web.ResponseWriter, errType int, address string, args ...interface{}) {
+	writeJSON(rw, []HueResponse{Error(NewHueError(errType, address, args...))})
+}
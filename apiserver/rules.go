@@ -0,0 +1,201 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/vhugo/rules"
+	"github.com/mlctrez/web"
+)
+
+// MaxRuleItems is the maximum number of conditions or actions of a rule on a hue bridge.
+const MaxRuleItems = 8
+
+type RuleRequest struct {
+	Name       *string                   `json:"name"`
+	Status     *string                   `json:"status"`
+	Recycle    *bool                     `json:"recycle"`
+	Conditions []*devicedb.RuleCondition `json:"conditions"`
+	Actions    []*devicedb.Command       `json:"actions"`
+}
+
+func writeRuleError(rw web.ResponseWriter, address string, err error) {
+	if strings.Contains(err.Error(), "does not exist") {
+		writeError(rw, ErrResourceNotAvailable, address, address)
+		return
+	}
+	writeError(rw, ErrInternal, address, err)
+}
+
+// validateRule returns the hue error for invalid conditions, actions or status.
+func validateRule(address string, rr *RuleRequest) *HueError {
+	if rr.Conditions != nil {
+		if len(rr.Conditions) == 0 || len(rr.Conditions) > MaxRuleItems {
+			return NewHueError(ErrTooManyItems, address+"/conditions")
+		}
+		for i, rc := range rr.Conditions {
+			if rc == nil {
+				return NewHueError(ErrInvalidValue, fmt.Sprintf("%s/conditions/%d", address, i), "null", "conditions")
+			}
+			if err := rules.ValidateCondition(rc); err != nil {
+				return NewHueError(ErrInvalidValue, fmt.Sprintf("%s/conditions/%d", address, i), rc.Address, "conditions")
+			}
+		}
+	}
+	if rr.Actions != nil {
+		if len(rr.Actions) == 0 || len(rr.Actions) > MaxRuleItems {
+			return NewHueError(ErrTooManyItems, address+"/actions")
+		}
+		for i, action := range rr.Actions {
+			actionAddress := fmt.Sprintf("%s/actions/%d", address, i)
+			if action == nil {
+				return NewHueError(ErrInvalidValue, actionAddress, "null", "actions")
+			}
+			if !strings.HasPrefix(action.Address, "/") || strings.HasPrefix(action.Address, "/api/") {
+				return NewHueError(ErrInvalidValue, actionAddress, action.Address, "address")
+			}
+			switch action.Method {
+			case http.MethodPut, http.MethodPost, http.MethodDelete:
+			default:
+				return NewHueError(ErrInvalidValue, actionAddress, action.Method, "method")
+			}
+		}
+	}
+	if rr.Status != nil && *rr.Status != "enabled" && *rr.Status != "disabled" {
+		return NewHueError(ErrInvalidValue, address+"/status", *rr.Status, "status")
+	}
+	return nil
+}
+
+func (c *ApiContext) Rules(rw web.ResponseWriter, req *web.Request) {
+	groupRules, err := c.server.DB.GetRules(c.server.DeviceGroup.GroupID)
+	if err != nil {
+		writeError(rw, ErrInternal, "/rules", err)
+		return
+	}
+	writeJSON(rw, groupRules)
+}
+
+func (c *ApiContext) Rule(rw web.ResponseWriter, req *web.Request) {
+	ruleID := req.PathParams["ruleID"]
+	address := "/rules/" + ruleID
+
+	rule, err := c.server.DB.GetRule(c.server.DeviceGroup.GroupID, ruleID)
+	if err != nil {
+		writeRuleError(rw, address, err)
+		return
+	}
+	writeJSON(rw, rule)
+}
+
+func (c *ApiContext) CreateRule(rw web.ResponseWriter, req *web.Request) {
+	rr := &RuleRequest{}
+	if err := json.NewDecoder(req.Body).Decode(rr); err != nil {
+		writeError(rw, ErrInvalidJSON, "/rules")
+		return
+	}
+	if rr.Conditions == nil || rr.Actions == nil {
+		writeError(rw, ErrMissingParameters, "/rules")
+		return
+	}
+	if he := validateRule("/rules", rr); he != nil {
+		writeJSON(rw, []HueResponse{Error(he)})
+		return
+	}
+
+	rule := &devicedb.Rule{
+		Name:          "rule",
+		Owner:         req.PathParams["userID"],
+		Created:       time.Now().UTC().Format(devicedb.HueTime),
+		LastTriggered: "none",
+		Status:        "enabled",
+		Conditions:    rr.Conditions,
+		Actions:       rr.Actions,
+	}
+	if rr.Name != nil {
+		rule.Name = *rr.Name
+	}
+	if rr.Status != nil {
+		rule.Status = *rr.Status
+	}
+	if rr.Recycle != nil {
+		rule.Recycle = *rr.Recycle
+	}
+
+	ruleID, err := c.server.DB.AddRule(c.server.DeviceGroup.GroupID, rule)
+	if err != nil {
+		writeError(rw, ErrInternal, "/rules", err)
+		return
+	}
+	writeJSON(rw, []HueResponse{Success(map[string]string{"id": ruleID})})
+}
+
+func (c *ApiContext) UpdateRule(rw web.ResponseWriter, req *web.Request) {
+	ruleID := req.PathParams["ruleID"]
+	address := "/rules/" + ruleID
+	groupID := c.server.DeviceGroup.GroupID
+
+	rr := &RuleRequest{}
+	if err := json.NewDecoder(req.Body).Decode(rr); err != nil {
+		writeError(rw, ErrInvalidJSON, address)
+		return
+	}
+	if he := validateRule(address, rr); he != nil {
+		writeJSON(rw, []HueResponse{Error(he)})
+		return
+	}
+	// recycle is set when the rule is created
+	if rr.Recycle != nil {
+		writeError(rw, ErrParameterNotModifiable, address+"/recycle", "recycle")
+		return
+	}
+
+	rule, err := c.server.DB.GetRule(groupID, ruleID)
+	if err != nil {
+		writeRuleError(rw, address, err)
+		return
+	}
+
+	response := make([]HueResponse, 0)
+	if rr.Name != nil {
+		rule.Name = *rr.Name
+		response = append(response, SuccessValue(address+"/name", rule.Name))
+	}
+	if rr.Status != nil {
+		rule.Status = *rr.Status
+		response = append(response, SuccessValue(address+"/status", rule.Status))
+	}
+	if rr.Conditions != nil {
+		rule.Conditions = rr.Conditions
+		response = append(response, SuccessValue(address+"/conditions", rule.Conditions))
+	}
+	if rr.Actions != nil {
+		rule.Actions = rr.Actions
+		response = append(response, SuccessValue(address+"/actions", rule.Actions))
+	}
+	if len(response) == 0 {
+		writeError(rw, ErrMissingParameters, address)
+		return
+	}
+
+	if err = c.server.DB.UpdateRule(groupID, ruleID, rule); err != nil {
+		writeError(rw, ErrInternal, address, err)
+		return
+	}
+	writeJSON(rw, response)
+}
+
+func (c *ApiContext) DeleteRule(rw web.ResponseWriter, req *web.Request) {
+	ruleID := req.PathParams["ruleID"]
+	address := "/rules/" + ruleID
+
+	if err := c.server.DB.DeleteRule(c.server.DeviceGroup.GroupID, ruleID); err != nil {
+		writeRuleError(rw, address, err)
+		return
+	}
+	writeJSON(rw, []HueResponse{Success(address + " deleted")})
+}
@@ -0,0 +1,231 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/vhugo/lightstate"
+	"github.com/mlctrez/web"
+)
+
+type SceneRequest struct {
+	Name            *string  `json:"name"`
+	Lights          []string `json:"lights"`
+	Recycle         *bool    `json:"recycle"`
+	Picture         *string  `json:"picture"`
+	StoreLightState *bool    `json:"storelightstate"`
+}
+
+func writeSceneError(rw web.ResponseWriter, address string, err error) {
+	if strings.Contains(err.Error(), "does not exist") {
+		writeError(rw, ErrResourceNotAvailable, address, address)
+		return
+	}
+	writeError(rw, ErrInternal, address, err)
+}
+
+// Scenes lists the scenes without their light states, as a hue bridge does.
+func (c *ApiContext) Scenes(rw web.ResponseWriter, req *web.Request) {
+	scenes, err := c.server.DB.GetScenes(c.server.DeviceGroup.GroupID)
+	if err != nil {
+		writeError(rw, ErrInternal, "/scenes", err)
+		return
+	}
+	for _, scene := range scenes {
+		scene.LightStates = nil
+	}
+	writeJSON(rw, scenes)
+}
+
+func (c *ApiContext) Scene(rw web.ResponseWriter, req *web.Request) {
+	sceneID := req.PathParams["sceneID"]
+	address := "/scenes/" + sceneID
+
+	scene, err := c.server.DB.GetScene(c.server.DeviceGroup.GroupID, sceneID)
+	if err != nil {
+		writeSceneError(rw, address, err)
+		return
+	}
+	writeJSON(rw, scene)
+}
+
+// CreateScene captures the current state of the requested lights into a new scene.
+func (c *ApiContext) CreateScene(rw web.ResponseWriter, req *web.Request) {
+	sr := &SceneRequest{}
+	if err := json.NewDecoder(req.Body).Decode(sr); err != nil {
+		writeError(rw, ErrInvalidJSON, "/scenes")
+		return
+	}
+	if sr.Name == nil || len(sr.Lights) == 0 {
+		writeError(rw, ErrMissingParameters, "/scenes")
+		return
+	}
+	if invalid, err := c.server.invalidLight(sr.Lights); err != nil {
+		writeError(rw, ErrInternal, "/scenes", err)
+		return
+	} else if invalid != "" {
+		writeError(rw, ErrInvalidValue, "/scenes/lights", invalid, "lights")
+		return
+	}
+
+	groupID := c.server.DeviceGroup.GroupID
+	lights, err := c.server.DB.GetVirtualLights(groupID)
+	if err != nil {
+		writeError(rw, ErrInternal, "/scenes", err)
+		return
+	}
+
+	scene := devicedb.NewScene(*sr.Name, req.PathParams["userID"], sr.Lights)
+	if sr.Recycle != nil {
+		scene.Recycle = *sr.Recycle
+	}
+	if sr.Picture != nil {
+		scene.Picture = *sr.Picture
+	}
+	scene.Capture(lights)
+
+	sceneID, err := c.server.DB.AddScene(groupID, scene)
+	if err != nil {
+		writeError(rw, ErrInternal, "/scenes", err)
+		return
+	}
+	writeJSON(rw, []HueResponse{Success(map[string]string{"id": sceneID})})
+}
+
+func (c *ApiContext) UpdateScene(rw web.ResponseWriter, req *web.Request) {
+	sceneID := req.PathParams["sceneID"]
+	address := "/scenes/" + sceneID
+	groupID := c.server.DeviceGroup.GroupID
+
+	sr := &SceneRequest{}
+	if err := json.NewDecoder(req.Body).Decode(sr); err != nil {
+		writeError(rw, ErrInvalidJSON, address)
+		return
+	}
+
+	scene, err := c.server.DB.GetScene(groupID, sceneID)
+	if err != nil {
+		writeSceneError(rw, address, err)
+		return
+	}
+
+	response := make([]HueResponse, 0)
+	if sr.Name != nil {
+		scene.Name = *sr.Name
+		response = append(response, SuccessValue(address+"/name", scene.Name))
+	}
+	if sr.Lights != nil {
+		if invalid, err := c.server.invalidLight(sr.Lights); err != nil {
+			writeError(rw, ErrInternal, address, err)
+			return
+		} else if invalid != "" {
+			writeError(rw, ErrInvalidValue, address+"/lights", invalid, "lights")
+			return
+		}
+		scene.Lights = sr.Lights
+		response = append(response, SuccessValue(address+"/lights", scene.Lights))
+	}
+	if sr.Picture != nil {
+		scene.Picture = *sr.Picture
+		response = append(response, SuccessValue(address+"/picture", scene.Picture))
+	}
+	if (sr.StoreLightState != nil && *sr.StoreLightState) || sr.Lights != nil {
+		lights, err := c.server.DB.GetVirtualLights(groupID)
+		if err != nil {
+			writeError(rw, ErrInternal, address, err)
+			return
+		}
+		scene.Capture(lights)
+		if sr.StoreLightState != nil {
+			response = append(response, SuccessValue(address+"/storelightstate", true))
+		}
+	}
+	if len(response) == 0 {
+		writeError(rw, ErrMissingParameters, address)
+		return
+	}
+
+	if err = c.server.DB.UpdateScene(groupID, sceneID, scene); err != nil {
+		writeError(rw, ErrInternal, address, err)
+		return
+	}
+	writeJSON(rw, response)
+}
+
+// SceneLightState replaces the stored state of a single light in the scene.
+func (c *ApiContext) SceneLightState(rw web.ResponseWriter, req *web.Request) {
+	sceneID := req.PathParams["sceneID"]
+	lightID := req.PathParams["lightID"]
+	address := "/scenes/" + sceneID + "/lightstates/" + lightID
+	groupID := c.server.DeviceGroup.GroupID
+
+	sr := &devicedb.StateRequest{}
+	if err := json.NewDecoder(req.Body).Decode(sr); err != nil {
+		writeError(rw, ErrInvalidJSON, address)
+		return
+	}
+	if sr.Empty() {
+		writeError(rw, ErrMissingParameters, address)
+		return
+	}
+
+	scene, err := c.server.DB.GetScene(groupID, sceneID)
+	if err != nil {
+		writeSceneError(rw, "/scenes/"+sceneID, err)
+		return
+	}
+	st, ok := scene.LightStates[lightID]
+	if !ok {
+		writeError(rw, ErrResourceNotAvailable, address, address)
+		return
+	}
+
+	vl := &devicedb.VirtualLight{State: st}
+	vl.UpdateState(sr)
+	scene.LightStates[lightID] = vl.State
+
+	if err = c.server.DB.UpdateScene(groupID, sceneID, scene); err != nil {
+		writeError(rw, ErrInternal, address, err)
+		return
+	}
+
+	applied := sr.Applied(vl.State)
+	response := make([]HueResponse, 0, len(applied))
+	for _, f := range applied {
+		response = append(response, SuccessValue(address+"/"+f.Name, f.Value))
+	}
+	writeJSON(rw, response)
+}
+
+func (c *ApiContext) DeleteScene(rw web.ResponseWriter, req *web.Request) {
+	sceneID := req.PathParams["sceneID"]
+	address := "/scenes/" + sceneID
+
+	if err := c.server.DB.DeleteScene(c.server.DeviceGroup.GroupID, sceneID); err != nil {
+		writeSceneError(rw, address, err)
+		return
+	}
+	writeJSON(rw, []HueResponse{Success(address + " deleted")})
+}
+
+// recallScene restores the stored state of the scene lights that are members of hg, in the order of the
+// group lights. failed holds an error for every light whose state could not be changed, err is set when
+// the scene itself could not be read.
+func (a *ApiServer) recallScene(hg *devicedb.HueGroup, sceneID string, o lightstate.Origin) (failed []*HueError, err error) {
+	scene, err := a.DB.GetScene(a.DeviceGroup.GroupID, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	for _, lightID := range hg.Lights {
+		st, ok := scene.LightStates[lightID]
+		if !ok {
+			continue
+		}
+		if _, err := a.changeLightState(lightID, devicedb.StateRequestFor(st), o); err != nil {
+			a.logger.Println("recallScene", sceneID, "light", lightID, err)
+			failed = append(failed, lightStateError(lightID, err))
+		}
+	}
+	return failed, nil
+}
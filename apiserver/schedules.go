@@ -0,0 +1,268 @@
+package apiserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/vhugo/scheduler"
+	"github.com/mlctrez/web"
+)
+
+// MaxSchedules is the schedule table size of a hue bridge.
+const MaxSchedules = 100
+
+type ScheduleRequest struct {
+	Name        *string           `json:"name"`
+	Description *string           `json:"description"`
+	Command     *devicedb.Command `json:"command"`
+	LocalTime   *string           `json:"localtime"`
+	Status      *string           `json:"status"`
+	AutoDelete  *bool             `json:"autodelete"`
+	Recycle     *bool             `json:"recycle"`
+}
+
+func writeScheduleError(rw web.ResponseWriter, address string, err error) {
+	if strings.Contains(err.Error(), "does not exist") {
+		writeError(rw, ErrResourceNotAvailable, address, address)
+		return
+	}
+	writeError(rw, ErrInternal, address, err)
+}
+
+// validateSchedule returns the hue error for an invalid command, time pattern or status.
+func validateSchedule(address string, sr *ScheduleRequest) *HueError {
+	if sr.Command != nil {
+		cmd := sr.Command
+		if !strings.HasPrefix(cmd.Address, "/api/") {
+			return NewHueError(ErrInvalidValue, address+"/command", cmd.Address, "address")
+		}
+		switch cmd.Method {
+		case http.MethodPut, http.MethodPost, http.MethodDelete:
+		default:
+			return NewHueError(ErrInvalidValue, address+"/command", cmd.Method, "method")
+		}
+	}
+	if sr.LocalTime != nil {
+		if _, err := scheduler.ParseTimePattern(*sr.LocalTime); err != nil {
+			return NewHueError(ErrInvalidValue, address+"/localtime", *sr.LocalTime, "localtime")
+		}
+	}
+	if sr.Status != nil && *sr.Status != "enabled" && *sr.Status != "disabled" {
+		return NewHueError(ErrInvalidValue, address+"/status", *sr.Status, "status")
+	}
+	return nil
+}
+
+// restartTimer sets the start time of timer schedules, which count from when they were enabled.
+func restartTimer(schedule *devicedb.Schedule) {
+	schedule.StartTime = ""
+	if tp, err := scheduler.ParseTimePattern(schedule.LocalTime); err == nil && tp.IsTimer() {
+		schedule.StartTime = time.Now().UTC().Format(devicedb.HueTime)
+	}
+}
+
+func (c *ApiContext) Schedules(rw web.ResponseWriter, req *web.Request) {
+	schedules, err := c.server.DB.GetSchedules(c.server.DeviceGroup.GroupID)
+	if err != nil {
+		writeError(rw, ErrInternal, "/schedules", err)
+		return
+	}
+	writeJSON(rw, schedules)
+}
+
+func (c *ApiContext) Schedule(rw web.ResponseWriter, req *web.Request) {
+	scheduleID := req.PathParams["scheduleID"]
+	address := "/schedules/" + scheduleID
+
+	schedule, err := c.server.DB.GetSchedule(c.server.DeviceGroup.GroupID, scheduleID)
+	if err != nil {
+		writeScheduleError(rw, address, err)
+		return
+	}
+	writeJSON(rw, schedule)
+}
+
+func (c *ApiContext) CreateSchedule(rw web.ResponseWriter, req *web.Request) {
+	groupID := c.server.DeviceGroup.GroupID
+
+	sr := &ScheduleRequest{}
+	if err := json.NewDecoder(req.Body).Decode(sr); err != nil {
+		writeError(rw, ErrInvalidJSON, "/schedules")
+		return
+	}
+	if sr.Command == nil || sr.LocalTime == nil {
+		writeError(rw, ErrMissingParameters, "/schedules")
+		return
+	}
+	if he := validateSchedule("/schedules", sr); he != nil {
+		writeJSON(rw, []HueResponse{Error(he)})
+		return
+	}
+
+	schedule := &devicedb.Schedule{
+		Name:       "schedule",
+		Command:    sr.Command,
+		LocalTime:  *sr.LocalTime,
+		Status:     "enabled",
+		AutoDelete: true,
+		Created:    time.Now().UTC().Format(devicedb.HueTime),
+	}
+	if sr.Name != nil {
+		schedule.Name = *sr.Name
+	}
+	if sr.Description != nil {
+		schedule.Description = *sr.Description
+	}
+	if sr.Status != nil {
+		schedule.Status = *sr.Status
+	}
+	if sr.AutoDelete != nil {
+		schedule.AutoDelete = *sr.AutoDelete
+	}
+	if sr.Recycle != nil {
+		schedule.Recycle = *sr.Recycle
+	}
+	restartTimer(schedule)
+
+	scheduleID, err := c.server.DB.AddSchedule(groupID, schedule, MaxSchedules)
+	if err != nil {
+		if strings.Contains(err.Error(), "is full") {
+			writeError(rw, ErrTooManyItems, "/schedules")
+			return
+		}
+		writeError(rw, ErrInternal, "/schedules", err)
+		return
+	}
+	writeJSON(rw, []HueResponse{Success(map[string]string{"id": scheduleID})})
+}
+
+func (c *ApiContext) UpdateSchedule(rw web.ResponseWriter, req *web.Request) {
+	scheduleID := req.PathParams["scheduleID"]
+	address := "/schedules/" + scheduleID
+	groupID := c.server.DeviceGroup.GroupID
+
+	sr := &ScheduleRequest{}
+	if err := json.NewDecoder(req.Body).Decode(sr); err != nil {
+		writeError(rw, ErrInvalidJSON, address)
+		return
+	}
+	if he := validateSchedule(address, sr); he != nil {
+		writeJSON(rw, []HueResponse{Error(he)})
+		return
+	}
+	// recycle is set when the schedule is created
+	if sr.Recycle != nil {
+		writeError(rw, ErrParameterNotModifiable, address+"/recycle", "recycle")
+		return
+	}
+
+	schedule, err := c.server.DB.GetSchedule(groupID, scheduleID)
+	if err != nil {
+		writeScheduleError(rw, address, err)
+		return
+	}
+
+	response := make([]HueResponse, 0)
+	if sr.Name != nil {
+		schedule.Name = *sr.Name
+		response = append(response, SuccessValue(address+"/name", schedule.Name))
+	}
+	if sr.Description != nil {
+		schedule.Description = *sr.Description
+		response = append(response, SuccessValue(address+"/description", schedule.Description))
+	}
+	if sr.Command != nil {
+		schedule.Command = sr.Command
+		response = append(response, SuccessValue(address+"/command", schedule.Command))
+	}
+	if sr.LocalTime != nil {
+		schedule.LocalTime = *sr.LocalTime
+		restartTimer(schedule)
+		response = append(response, SuccessValue(address+"/localtime", schedule.LocalTime))
+	}
+	if sr.Status != nil {
+		if *sr.Status == "enabled" && schedule.Status != "enabled" {
+			restartTimer(schedule)
+		}
+		schedule.Status = *sr.Status
+		response = append(response, SuccessValue(address+"/status", schedule.Status))
+	}
+	if sr.AutoDelete != nil {
+		schedule.AutoDelete = *sr.AutoDelete
+		response = append(response, SuccessValue(address+"/autodelete", schedule.AutoDelete))
+	}
+	if len(response) == 0 {
+		writeError(rw, ErrMissingParameters, address)
+		return
+	}
+
+	if err = c.server.DB.UpdateSchedule(groupID, scheduleID, schedule); err != nil {
+		writeError(rw, ErrInternal, address, err)
+		return
+	}
+	writeJSON(rw, response)
+}
+
+func (c *ApiContext) DeleteSchedule(rw web.ResponseWriter, req *web.Request) {
+	scheduleID := req.PathParams["scheduleID"]
+	address := "/schedules/" + scheduleID
+
+	if err := c.server.DB.DeleteSchedule(c.server.DeviceGroup.GroupID, scheduleID); err != nil {
+		writeScheduleError(rw, address, err)
+		return
+	}
+	writeJSON(rw, []HueResponse{Success(address + " deleted")})
+}
+
+// Execute runs a schedule or rule command through the api routes as if a hue client sent it.
+// The source is reported as the origin of the light state changes the command makes.
+func (a *ApiServer) Execute(cmd *devicedb.Command, source string) error {
+	req, err := http.NewRequest(cmd.Method, cmd.Address, bytes.NewReader(cmd.Body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(context.WithValue(req.Context(), sourceKey{}, source))
+	rec := &commandRecorder{header: http.Header{}}
+	a.router.ServeHTTP(rec, req)
+
+	var responses []map[string]json.RawMessage
+	if err = json.Unmarshal(rec.body.Bytes(), &responses); err != nil {
+		// not a success/error array, such as a GET of a resource
+		return nil
+	}
+	for _, r := range responses {
+		if errorBytes, ok := r["error"]; ok {
+			he := &HueError{}
+			if err = json.Unmarshal(errorBytes, he); err != nil {
+				return err
+			}
+			return fmt.Errorf("hue error %d at %s: %s", he.Type, he.Address, he.Description)
+		}
+	}
+	return nil
+}
+
+// commandRecorder is the http.ResponseWriter for commands executed without a client connection.
+type commandRecorder struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func (r *commandRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *commandRecorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+func (r *commandRecorder) WriteHeader(status int) {
+	r.status = status
+}
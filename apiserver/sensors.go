@@ -0,0 +1,254 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/vhugo/rules"
+	"github.com/mlctrez/web"
+)
+
+type SensorRequest struct {
+	Name             *string `json:"name"`
+	Type             *string `json:"type"`
+	ModelID          *string `json:"modelid"`
+	SwVersion        *string `json:"swversion"`
+	UniqueID         *string `json:"uniqueid"`
+	ManufacturerName *string `json:"manufacturername"`
+}
+
+type SensorStateRequest struct {
+	Flag     *bool `json:"flag"`
+	Status   *int  `json:"status"`
+	Daylight *bool `json:"daylight"`
+}
+
+type SensorConfigRequest struct {
+	On            *bool   `json:"on"`
+	Lat           *string `json:"lat"`
+	Long          *string `json:"long"`
+	SunriseOffset *int    `json:"sunriseoffset"`
+	SunsetOffset  *int    `json:"sunsetoffset"`
+}
+
+func writeSensorError(rw web.ResponseWriter, address string, err error) {
+	if strings.Contains(err.Error(), "does not exist") {
+		writeError(rw, ErrResourceNotAvailable, address, address)
+		return
+	}
+	writeError(rw, ErrInternal, address, err)
+}
+
+func (c *ApiContext) Sensors(rw web.ResponseWriter, req *web.Request) {
+	sensors, err := c.server.DB.GetSensors(c.server.DeviceGroup.GroupID)
+	if err != nil {
+		writeError(rw, ErrInternal, "/sensors", err)
+		return
+	}
+	writeJSON(rw, sensors)
+}
+
+func (c *ApiContext) Sensor(rw web.ResponseWriter, req *web.Request) {
+	sensorID := req.PathParams["sensorID"]
+	address := "/sensors/" + sensorID
+
+	sensor, err := c.server.DB.GetSensor(c.server.DeviceGroup.GroupID, sensorID)
+	if err != nil {
+		writeSensorError(rw, address, err)
+		return
+	}
+	writeJSON(rw, sensor)
+}
+
+func (c *ApiContext) CreateSensor(rw web.ResponseWriter, req *web.Request) {
+	sr := &SensorRequest{}
+	if err := json.NewDecoder(req.Body).Decode(sr); err != nil {
+		writeError(rw, ErrInvalidJSON, "/sensors")
+		return
+	}
+	if sr.Name == nil || sr.Type == nil {
+		writeError(rw, ErrMissingParameters, "/sensors")
+		return
+	}
+	sensor := devicedb.NewSensor(*sr.Name, *sr.Type)
+	if sensor == nil {
+		writeError(rw, ErrInvalidValue, "/sensors/type", *sr.Type, "type")
+		return
+	}
+	if sr.ModelID != nil {
+		sensor.ModelID = *sr.ModelID
+	}
+	if sr.SwVersion != nil {
+		sensor.SwVersion = *sr.SwVersion
+	}
+	if sr.UniqueID != nil {
+		sensor.UniqueID = *sr.UniqueID
+	}
+	if sr.ManufacturerName != nil {
+		sensor.ManufacturerName = *sr.ManufacturerName
+	}
+
+	sensorID, err := c.server.DB.AddSensor(c.server.DeviceGroup.GroupID, sensor)
+	if err != nil {
+		writeError(rw, ErrInternal, "/sensors", err)
+		return
+	}
+	writeJSON(rw, []HueResponse{Success(map[string]string{"id": sensorID})})
+}
+
+func (c *ApiContext) UpdateSensor(rw web.ResponseWriter, req *web.Request) {
+	sensorID := req.PathParams["sensorID"]
+	address := "/sensors/" + sensorID
+	groupID := c.server.DeviceGroup.GroupID
+
+	sr := &SensorRequest{}
+	if err := json.NewDecoder(req.Body).Decode(sr); err != nil {
+		writeError(rw, ErrInvalidJSON, address)
+		return
+	}
+	if sr.Name == nil {
+		writeError(rw, ErrMissingParameters, address)
+		return
+	}
+
+	sensor, err := c.server.DB.GetSensor(groupID, sensorID)
+	if err != nil {
+		writeSensorError(rw, address, err)
+		return
+	}
+	sensor.Name = *sr.Name
+	if err = c.server.DB.UpdateSensor(groupID, sensorID, sensor); err != nil {
+		writeError(rw, ErrInternal, address, err)
+		return
+	}
+	writeJSON(rw, []HueResponse{SuccessValue(address+"/name", sensor.Name)})
+}
+
+// SensorState sets the state attributes of a virtual sensor and publishes a sensorStateChange for the rules.
+func (c *ApiContext) SensorState(rw web.ResponseWriter, req *web.Request) {
+	sensorID := req.PathParams["sensorID"]
+	address := "/sensors/" + sensorID + "/state"
+	groupID := c.server.DeviceGroup.GroupID
+
+	sr := &SensorStateRequest{}
+	if err := json.NewDecoder(req.Body).Decode(sr); err != nil {
+		writeError(rw, ErrInvalidJSON, address)
+		return
+	}
+
+	sensor, err := c.server.DB.GetSensor(groupID, sensorID)
+	if err != nil {
+		writeSensorError(rw, "/sensors/"+sensorID, err)
+		return
+	}
+
+	response := make([]HueResponse, 0)
+	changed := make([]string, 0)
+	if sr.Flag != nil {
+		if sensor.State.Flag == nil {
+			writeError(rw, ErrParameterNotAvailable, address+"/flag", "flag")
+			return
+		}
+		sensor.State.Flag = sr.Flag
+		changed = append(changed, "flag")
+		response = append(response, SuccessValue(address+"/flag", *sr.Flag))
+	}
+	if sr.Status != nil {
+		if sensor.State.Status == nil {
+			writeError(rw, ErrParameterNotAvailable, address+"/status", "status")
+			return
+		}
+		sensor.State.Status = sr.Status
+		changed = append(changed, "status")
+		response = append(response, SuccessValue(address+"/status", *sr.Status))
+	}
+	if sr.Daylight != nil {
+		writeError(rw, ErrParameterNotModifiable, address+"/daylight", "daylight")
+		return
+	}
+	if len(response) == 0 {
+		writeError(rw, ErrMissingParameters, address)
+		return
+	}
+
+	sensor.Touch()
+	changed = append(changed, "lastupdated")
+	if err = c.server.DB.UpdateSensor(groupID, sensorID, sensor); err != nil {
+		writeError(rw, ErrInternal, address, err)
+		return
+	}
+	c.server.NS.Publish("sensorStateChange", &rules.SensorStateChange{GroupID: groupID, SensorID: sensorID, Changed: changed})
+	writeJSON(rw, response)
+}
+
+func (c *ApiContext) SensorConfig(rw web.ResponseWriter, req *web.Request) {
+	sensorID := req.PathParams["sensorID"]
+	address := "/sensors/" + sensorID + "/config"
+	groupID := c.server.DeviceGroup.GroupID
+
+	sr := &SensorConfigRequest{}
+	if err := json.NewDecoder(req.Body).Decode(sr); err != nil {
+		writeError(rw, ErrInvalidJSON, address)
+		return
+	}
+
+	sensor, err := c.server.DB.GetSensor(groupID, sensorID)
+	if err != nil {
+		writeSensorError(rw, "/sensors/"+sensorID, err)
+		return
+	}
+
+	response := make([]HueResponse, 0)
+	if sr.On != nil {
+		sensor.Config.On = *sr.On
+		response = append(response, SuccessValue(address+"/on", *sr.On))
+	}
+	if sr.Lat != nil || sr.Long != nil || sr.SunriseOffset != nil || sr.SunsetOffset != nil {
+		if sensor.Type != devicedb.SensorDaylight {
+			writeError(rw, ErrParameterNotAvailable, address, "lat/long")
+			return
+		}
+	}
+	if sr.Lat != nil {
+		sensor.Config.Lat = sr.Lat
+		response = append(response, SuccessValue(address+"/lat", "none"))
+	}
+	if sr.Long != nil {
+		sensor.Config.Long = sr.Long
+		response = append(response, SuccessValue(address+"/long", "none"))
+	}
+	if sr.SunriseOffset != nil {
+		sensor.Config.SunriseOffset = sr.SunriseOffset
+		response = append(response, SuccessValue(address+"/sunriseoffset", *sr.SunriseOffset))
+	}
+	if sr.SunsetOffset != nil {
+		sensor.Config.SunsetOffset = sr.SunsetOffset
+		response = append(response, SuccessValue(address+"/sunsetoffset", *sr.SunsetOffset))
+	}
+	if len(response) == 0 {
+		writeError(rw, ErrMissingParameters, address)
+		return
+	}
+	if sensor.Type == devicedb.SensorDaylight {
+		configured := sensor.Config.Lat != nil && sensor.Config.Long != nil
+		sensor.Config.Configured = &configured
+	}
+
+	if err = c.server.DB.UpdateSensor(groupID, sensorID, sensor); err != nil {
+		writeError(rw, ErrInternal, address, err)
+		return
+	}
+	writeJSON(rw, response)
+}
+
+func (c *ApiContext) DeleteSensor(rw web.ResponseWriter, req *web.Request) {
+	sensorID := req.PathParams["sensorID"]
+	address := "/sensors/" + sensorID
+
+	if err := c.server.DB.DeleteSensor(c.server.DeviceGroup.GroupID, sensorID); err != nil {
+		writeSensorError(rw, address, err)
+		return
+	}
+	writeJSON(rw, []HueResponse{Success(address + " deleted")})
+}
@@ -0,0 +1,73 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/web"
+)
+
+type CreateUserRequest struct {
+	DeviceType string `json:"devicetype"`
+}
+
+// CreateUser registers a new whitelist user, only allowed while the link button is pressed.
+func (c *ApiContext) CreateUser(rw web.ResponseWriter, req *web.Request) {
+	cu := &CreateUserRequest{}
+	if err := json.NewDecoder(req.Body).Decode(cu); err != nil {
+		writeError(rw, ErrInvalidJSON, "")
+		return
+	}
+	if cu.DeviceType == "" {
+		writeError(rw, ErrMissingParameters, "/devicetype")
+		return
+	}
+
+	pressed, err := c.server.DB.LinkButtonPressed()
+	if err != nil {
+		writeError(rw, ErrInternal, "", err)
+		return
+	}
+	if !pressed {
+		writeError(rw, ErrLinkButtonNotPressed, "")
+		return
+	}
+
+	user, err := c.server.DB.AddWhitelistUser(cu.DeviceType)
+	if err != nil {
+		writeError(rw, ErrInternal, "", err)
+		return
+	}
+	c.server.logger.Println("registered user", user.Username, "for", user.Name)
+	writeJSON(rw, []HueResponse{Success(map[string]string{"username": user.Username})})
+}
+
+// Authorize rejects requests from users not present in the whitelist and records the last use of the others.
+func (c *ApiContext) Authorize(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	userID := req.PathParams["userID"]
+	user, err := c.server.DB.GetWhitelistUser(userID)
+	if err != nil {
+		if !strings.Contains(err.Error(), "does not exist") {
+			c.server.logger.Println("GetWhitelistUser", err)
+		}
+		address := strings.TrimPrefix(req.URL.Path, "/api/"+userID)
+		if address == "" {
+			address = "/"
+		}
+		writeError(rw, ErrUnauthorizedUser, address)
+		return
+	}
+	c.server.recordUse(user)
+	next(rw, req)
+}
+
+// recordUse updates the last use date of the user when it is older than devicedb.LastUseInterval.
+func (a *ApiServer) recordUse(user *devicedb.WhitelistUser) {
+	if now := time.Now(); user.LastUseStale(now) {
+		if err := a.DB.UseWhitelistUser(user.Username, now); err != nil {
+			a.logger.Println("UseWhitelistUser", err)
+		}
+	}
+}
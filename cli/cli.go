@@ -2,23 +2,32 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/kardianos/service"
 	"github.com/mlctrez/servicego"
 	"github.com/mlctrez/vhugo/apiserver"
+	"github.com/mlctrez/vhugo/command"
+	"github.com/mlctrez/vhugo/config"
 	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/vhugo/devicegroups"
 	"github.com/mlctrez/vhugo/hlog"
+	"github.com/mlctrez/vhugo/lightstate"
+	"github.com/mlctrez/vhugo/mqttbridge"
 	"github.com/mlctrez/vhugo/natsserver"
+	"github.com/mlctrez/vhugo/network"
+	"github.com/mlctrez/vhugo/rules"
+	"github.com/mlctrez/vhugo/scheduler"
+	"github.com/mlctrez/vhugo/ssdp"
 	"github.com/mlctrez/vhugo/webapp"
+	"github.com/mlctrez/vhugo/webhook"
 	"github.com/mlctrez/web"
-	"github.com/nats-io/gnatsd/server"
+	"golang.org/x/net/ipv4"
 )
 
 type serv struct {
@@ -28,22 +37,13 @@ type serv struct {
 }
 
 func (sv *serv) Start(s service.Service) error {
-	sv.ctx, sv.cancel = context.WithCancel(context.Background())
-
-	port := 19200
-
-	if providedPort, err := strconv.Atoi(os.Getenv("PORT")); err == nil {
-		port = providedPort
-	}
-
-	ip := os.Getenv("IP")
-	if ip == "" {
-		return fmt.Errorf("IP environment variable not set")
+	cfg, err := config.Load(flag.CommandLine, flags)
+	if err != nil {
+		return err
 	}
 
-	tlsHostName := os.Getenv("TLS_HOST")
-
-	return Run(ip, port, tlsHostName, sv.ctx)
+	sv.ctx, sv.cancel = context.WithCancel(context.Background())
+	return Run(cfg, sv.ctx)
 }
 
 func (sv *serv) Stop(s service.Service) error {
@@ -53,29 +53,40 @@ func (sv *serv) Stop(s service.Service) error {
 	return nil
 }
 
+var flags *config.Flags
+
 func main() {
+	flags = config.RegisterFlags(flag.CommandLine)
 	servicego.Run(&serv{})
 }
 
-func Run(ip string, port int, tlsHostName string, mainContext context.Context) error {
-
-	natsPort := port + 1
-	apiPort := port + 2
+func Run(cfg *config.Config, mainContext context.Context) error {
 
 	logger := log.New(os.Stdout, "", 0)
 	web.Logger = logger
 
 	ml := hlog.New(logger, "Main")
 
-	opts := &server.Options{Host: ip, Port: natsPort, NoSigs: true}
+	interfaces, err := network.Select(cfg.Interface, cfg.IP)
+	if err != nil {
+		return err
+	}
+	if cfg.IP == "" {
+		cfg.IP = interfaces[0].IP().String()
+		ml.Println("detected ip", cfg.IP, "on", interfaces[0].Name)
+	}
 
-	ns := natsserver.New(opts, logger)
+	natsConfig := cfg.NATS
+	natsConfig.Host = cfg.IP
+	natsConfig.Port = cfg.NatsPort()
+
+	ns := natsserver.New(&natsConfig, logger)
 	startError := ns.Start(mainContext)
 	if startError != nil {
 		return startError
 	}
 
-	deviceDB, err := devicedb.New("device.db", logger)
+	deviceDB, err := devicedb.New(cfg.Database, logger)
 	if err != nil {
 		return err
 	}
@@ -87,43 +98,37 @@ func Run(ip string, port int, tlsHostName string, mainContext context.Context) e
 		}
 	}()
 
-	webAddr := fmt.Sprintf("%s:%d", ip, port)
-
-	app := webapp.New(deviceDB, ns, logger, tlsHostName)
-	go app.Run(webAddr, mainContext)
+	webAddr := fmt.Sprintf("%s:%d", cfg.IP, cfg.Port)
 
-	// TODO: configure the max number of device groups
-	for i := apiPort; i < apiPort+4; i++ {
-
-		groupID := fmt.Sprintf("group%d", i)
-		ml.Println("checking initial device group", groupID)
-		if _, err := deviceDB.GetDeviceGroup(groupID); err != nil {
-			group := devicedb.NewDeviceGroup(groupID)
-			group.ServerIP = ip
-			group.ServerPort = i
-			ml.Println("adding device group", group)
-			err := deviceDB.AddDeviceGroup(group)
-			if err != nil {
-				ml.Println("error creating device group", err)
-				return nil
-			}
-		}
-	}
+	lights := lightstate.New(deviceDB, ns, logger)
 
-	deviceGroups, err := deviceDB.GetDeviceGroups()
-	if err != nil {
+	sched := scheduler.New(deviceDB, logger)
+	engine := rules.New(deviceDB, ns, logger)
+	groups := devicegroups.New(cfg, deviceDB, ns, lights, sched, engine, logger)
+	groups.Interfaces = interfaces
+	if err = groups.Start(mainContext); err != nil {
 		return err
 	}
-	for _, dg := range deviceGroups {
-		deviceGroup := dg
-		ml.Println("starting", deviceGroup)
-		go apiserver.New(deviceDB, deviceGroup, ns, logger).Run(mainContext)
+
+	commands := command.New(cfg.Commands, deviceDB, ns, logger)
+	app := webapp.New(deviceDB, ns, lights, groups, commands, logger, cfg.TLSHost)
+	go app.Run(webAddr, mainContext)
+
+	if cfg.MQTT.Enabled() {
+		mqttConfig := cfg.MQTT
+		go mqttbridge.New(&mqttConfig, deviceDB, ns, logger).Run(mainContext)
 	}
-	go listenUPnP(ns, hlog.New(logger, "ListenUPnP"), mainContext)
+	go webhook.New(deviceDB, ns, logger).Run(mainContext)
+	go commands.Run(mainContext)
+	go sched.Run(mainContext)
+	go engine.Run(mainContext)
+	go listenUPnP(cfg.SSDPAddress, interfaces, ns, hlog.New(logger, "ListenUPnP"), mainContext)
 	return nil
 }
 
-func listenUPnP(ns natsserver.NatsPublisher, logger *hlog.HLog, ctx context.Context) {
+// listenUPnP reads ssdp discovery requests on each of the interfaces, or the default multicast
+// interface when there are none, and publishes them with the local ip the requester can reach.
+func listenUPnP(ssdpAddress string, interfaces []*network.Interface, ns natsserver.NatsPublisher, logger *hlog.HLog, ctx context.Context) {
 
 	logger.Println("setting up uPnP listener")
 
@@ -134,17 +139,34 @@ func listenUPnP(ns natsserver.NatsPublisher, logger *hlog.HLog, ctx context.Cont
 	var addr *net.UDPAddr
 	var conn *net.UDPConn
 
-	if addr, err = net.ResolveUDPAddr("udp4", "239.255.255.250:1900"); err != nil {
+	var first *net.Interface
+	if len(interfaces) > 0 {
+		first = interfaces[0].Interface
+	}
+	if addr, err = net.ResolveUDPAddr("udp4", ssdpAddress); err != nil {
 		logger.Println("ResolveUDPAddr", err)
 		return
 	} else {
-		if conn, err = net.ListenMulticastUDP("udp4", nil, addr); err != nil {
+		if conn, err = net.ListenMulticastUDP("udp4", first, addr); err != nil {
 			logger.Println("ListenMulticastUDP", err)
 			return
 		}
 	}
 	defer conn.Close()
 
+	pc := ipv4.NewPacketConn(conn)
+	for _, i := range interfaces {
+		if i.Interface != first {
+			if err = pc.JoinGroup(i.Interface, addr); err != nil {
+				logger.Println("JoinGroup", i.Name, err)
+			}
+		}
+		logger.Println("listening on", i.Name, i.IP())
+	}
+	if err = pc.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		logger.Println("SetControlMessage", err)
+	}
+
 	go func() {
 		var buf [1024]byte
 
@@ -154,17 +176,28 @@ func listenUPnP(ns natsserver.NatsPublisher, logger *hlog.HLog, ctx context.Cont
 				logger.Println("exit in select")
 				return
 			default:
-				if packetLength, remote, err := conn.ReadFromUDP(buf[:]); err == nil {
-					packetString := string(buf[:packetLength])
-
-					if strings.Contains(packetString, "ST: urn:schemas-upnp-org:device:basic:1") {
-						d := &apiserver.DiscoveryRequest{Remote: remote.String(), Packet: packetString}
-						ns.Publish("upnp.discovery", d)
-					}
+				packetLength, cm, remote, err := pc.ReadFrom(buf[:])
+				if err != nil {
+					logger.Println("ReadFrom", err)
+					continue
 				}
+				packetString := string(buf[:packetLength])
+				search, err := ssdp.ParseSearch(packetString)
 				if err != nil {
-					logger.Println("ReadFromUDP", err)
+					continue
+				}
+
+				d := &apiserver.DiscoveryRequest{Remote: remote.String(), Packet: packetString, ST: search.ST, MX: search.MX}
+				if len(interfaces) > 0 && cm != nil {
+					i := network.Find(interfaces, cm.IfIndex)
+					if i == nil {
+						continue
+					}
+					if udpAddr, ok := remote.(*net.UDPAddr); ok {
+						d.LocalIP = i.LocalIP(udpAddr.IP).String()
+					}
 				}
+				ns.Publish("upnp.discovery", d)
 			}
 		}
 	}()
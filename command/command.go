@@ -0,0 +1,290 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/vhugo/hlog"
+	"github.com/mlctrez/vhugo/lightstate"
+	"github.com/mlctrez/vhugo/natsserver"
+)
+
+// DefaultTimeout is how long a command without a timeout may run, MaxOutput is the amount of
+// combined output kept in the delivery log and QueueSize the number of state changes of a light
+// waiting for its running commands before further changes are dropped.
+const (
+	DefaultTimeout = 10 * time.Second
+	MaxOutput      = 1024
+	QueueSize      = 64
+)
+
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Config is the operator opt-in for commands. Lights can only run the programs in Allow, and
+// nothing at all unless Enabled is set, since anyone who can reach the web app can store commands.
+type Config struct {
+	Enabled bool
+	// Allow are the absolute paths of the programs lights may run.
+	Allow []string
+}
+
+// Allowed is true when commands are enabled and path is in the allowlist.
+func (c *Config) Allowed(path string) bool {
+	if !c.Enabled || !filepath.IsAbs(path) {
+		return false
+	}
+	for _, allowed := range c.Allow {
+		if filepath.Clean(allowed) == filepath.Clean(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the path, condition and argument templates of a command before it is stored.
+func (r *Runner) Validate(c *devicedb.LightCommand) error {
+	if strings.TrimSpace(c.Path) == "" {
+		return fmt.Errorf("invalid command, path is required")
+	}
+	if !r.config.Enabled {
+		return fmt.Errorf("invalid command %s, commands are disabled", c.Path)
+	}
+	if !r.config.Allowed(c.Path) {
+		return fmt.Errorf("invalid command %s, the path is not in commands.allow", c.Path)
+	}
+	switch c.When {
+	case "", "on", "off":
+	default:
+		return fmt.Errorf("invalid command when %s, must be on, off or empty", c.When)
+	}
+	for _, arg := range c.Args {
+		if _, err := template.New("arg").Funcs(funcs).Parse(arg); err != nil {
+			return fmt.Errorf("invalid command argument template, %v", err)
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("invalid command timeout %d", c.Timeout)
+	}
+	return nil
+}
+
+// ValidateOptions validates the commands of a light. The commands of a state change run one after
+// another and the change is acknowledged when they all exited, so on a light that acknowledges state
+// changes their timeouts together must stay below the ack timeout. Otherwise a slow command marks
+// the light unreachable before it is killed.
+func (r *Runner) ValidateOptions(lo *devicedb.LightOptions) error {
+	var total time.Duration
+	for _, c := range lo.Commands {
+		if err := r.Validate(c); err != nil {
+			return err
+		}
+		total += timeout(c)
+	}
+	if ack := lo.Timeout(); ack > 0 && total >= ack {
+		return fmt.Errorf("invalid command timeout, the commands may run %s which is not below the ack timeout %s", total, ack)
+	}
+	return nil
+}
+
+// timeout is the time the command may run, DefaultTimeout when it has none.
+func timeout(c *devicedb.LightCommand) time.Duration {
+	if c.Timeout > 0 {
+		return time.Duration(c.Timeout) * time.Millisecond
+	}
+	return DefaultTimeout
+}
+
+// matches is true when the command should run for the event.
+func matches(c *devicedb.LightCommand, event *lightstate.Event) bool {
+	switch c.When {
+	case "on":
+		return event.StateRequest != nil && event.StateRequest.On != nil && *event.StateRequest.On
+	case "off":
+		return event.StateRequest != nil && event.StateRequest.On != nil && !*event.StateRequest.On
+	}
+	return true
+}
+
+func args(c *devicedb.LightCommand, event *lightstate.Event) (rendered []string, err error) {
+	for _, arg := range c.Args {
+		var t *template.Template
+		if t, err = template.New("arg").Funcs(funcs).Parse(arg); err != nil {
+			return nil, err
+		}
+		buf := &bytes.Buffer{}
+		if err = t.Execute(buf, event); err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, buf.String())
+	}
+	return rendered, nil
+}
+
+// Runner runs the commands of a light on each of its state changes and records the exit code and
+// output in the delivery log of the light. Each light has its own queue, the state changes of a light
+// are handled one at a time in the order they were published while other lights run concurrently.
+type Runner struct {
+	DB     *devicedb.DeviceDB
+	NS     *natsserver.NatsServer
+	config Config
+	logger *hlog.HLog
+	lock   sync.Mutex
+	queues map[string]chan *queued
+}
+
+// queued is a state change waiting for the earlier commands of its light.
+type queued struct {
+	reply string
+	event *lightstate.Event
+}
+
+func New(config Config, db *devicedb.DeviceDB, ns *natsserver.NatsServer, logger *log.Logger) *Runner {
+	return &Runner{DB: db, NS: ns, config: config, logger: hlog.New(logger, "Commands"),
+		queues: make(map[string]chan *queued)}
+}
+
+func (r *Runner) Run(ctx context.Context) {
+	r.logger.Println("Run() entry")
+	if !r.config.Enabled {
+		r.logger.Println("commands are disabled")
+		return
+	}
+
+	subscription, err := r.NS.Subscribe(lightstate.LightSubject("*", "*", lightstate.KindState),
+		func(subject, reply string, event *lightstate.Event) {
+			r.enqueue(ctx, reply, event)
+		})
+	if err != nil {
+		r.logger.Println("Subscribe", err)
+		return
+	}
+	defer subscription.Unsubscribe()
+
+	<-ctx.Done()
+	r.logger.Println("Run() exit")
+}
+
+// enqueue hands the event to the worker of its light, starting the worker on the first event. A full
+// queue drops the event rather than blocking the subscription of every light, an acknowledged change
+// then times out and marks the light unreachable.
+func (r *Runner) enqueue(ctx context.Context, reply string, event *lightstate.Event) {
+	key := event.GroupID + "." + event.LightID
+	r.lock.Lock()
+	queue, ok := r.queues[key]
+	if !ok {
+		queue = make(chan *queued, QueueSize)
+		r.queues[key] = queue
+		go r.work(ctx, queue)
+	}
+	r.lock.Unlock()
+
+	select {
+	case queue <- &queued{reply: reply, event: event}:
+	default:
+		r.logger.Println("queue full, dropping state change", event.GroupID, event.LightID)
+	}
+}
+
+// work dispatches the events of one light in order until the context is done.
+func (r *Runner) work(ctx context.Context, queue chan *queued) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case q := <-queue:
+			r.dispatch(ctx, q.reply, q.event)
+		}
+	}
+}
+
+// dispatch runs the matching commands of the light in order. When the light acknowledges state
+// changes the change is acknowledged after every command exited with a zero exit code.
+func (r *Runner) dispatch(ctx context.Context, reply string, event *lightstate.Event) {
+	lo, err := r.DB.GetLightOptions(event.GroupID, event.LightID)
+	if err != nil {
+		r.logger.Println("GetLightOptions", event.GroupID, event.LightID, err)
+		return
+	}
+	ran, succeeded := false, true
+	for _, c := range lo.Commands {
+		if !matches(c, event) {
+			continue
+		}
+		ran = true
+		delivery := r.run(ctx, c, event)
+		r.logger.Println("command", event.GroupID, event.LightID, delivery.Target,
+			"exit", delivery.Status, delivery.Error, strings.TrimSpace(delivery.Output))
+		if delivery.Error != "" {
+			succeeded = false
+		}
+		if err = r.DB.AddDelivery(event.GroupID, event.LightID, delivery); err != nil {
+			r.logger.Println("AddDelivery", event.GroupID, event.LightID, err)
+		}
+	}
+	if ran && succeeded && reply != "" {
+		r.NS.Publish(reply, map[string]string{"dispatcher": "command"})
+	}
+}
+
+func (r *Runner) run(ctx context.Context, c *devicedb.LightCommand, event *lightstate.Event) *devicedb.Delivery {
+	start := time.Now()
+	delivery := &devicedb.Delivery{
+		Timestamp: start.UTC().Format(devicedb.HueTime),
+		Action:    "exec",
+		Target:    c.Path,
+		Attempts:  1,
+	}
+	defer func() {
+		delivery.Duration = time.Since(start).Milliseconds()
+	}()
+
+	rendered, err := args(c, event)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	delivery.Target = strings.Join(append([]string{c.Path}, rendered...), " ")
+	// commands stored before the allowlist changed are not run
+	if !r.config.Allowed(c.Path) {
+		delivery.Error = "the path is not in commands.allow"
+		return delivery
+	}
+
+	timeout := timeout(c)
+	runContext, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(runContext, c.Path, rendered...).CombinedOutput()
+	if len(output) > MaxOutput {
+		output = output[len(output)-MaxOutput:]
+	}
+	delivery.Output = string(output)
+
+	switch e := err.(type) {
+	case nil:
+	case *exec.ExitError:
+		delivery.Status = e.ExitCode()
+		delivery.Error = err.Error()
+		if runContext.Err() == context.DeadlineExceeded {
+			delivery.Error = fmt.Sprintf("killed after %s", timeout)
+		}
+	default:
+		delivery.Error = err.Error()
+	}
+	return delivery
+}
@@ -0,0 +1,391 @@
+// Package config loads the vhugo settings from defaults, a yaml, json or toml file, environment
+// variables and command line flags, in that order of precedence.
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mlctrez/vhugo/command"
+	"github.com/mlctrez/vhugo/mqttbridge"
+	"github.com/mlctrez/vhugo/natsserver"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFiles are the config files read from the working directory when none is given.
+var DefaultFiles = []string{"vhugo.yaml", "vhugo.yml", "vhugo.json", "vhugo.toml"}
+
+// Config is the validated configuration passed to Run.
+type Config struct {
+	// IP is the address the servers listen on and advertise, detected from the interfaces when empty.
+	IP string
+	// Interface limits the detection and ssdp discovery to the named network interface.
+	Interface string
+	Port      int
+	TLSHost   string
+	// Database is the path of the bolt database.
+	Database string
+	// DeviceGroups is the number of device groups created on the first start, each served by a hue
+	// api server on its own port. MaxDeviceGroups limits the groups added later from the web app.
+	DeviceGroups    int
+	MaxDeviceGroups int
+	// NatsPortOffset and APIPortOffset are added to Port for the nats server and the first api server.
+	NatsPortOffset    int
+	APIPortOffset     int
+	MaxLightsPerGroup int
+	// Placement is the strategy that picks the device group of a new light, fill-first, round-robin
+	// or pinned to PlacementGroup.
+	Placement      string
+	PlacementGroup string
+	// SSDPAddress is the multicast address and port the upnp discovery requests are read from.
+	SSDPAddress string
+	NATS        natsserver.Config
+	MQTT        mqttbridge.Config
+	// Commands must be enabled, and every program listed in its allowlist, before lights can run local programs.
+	Commands command.Config
+}
+
+// Default returns the configuration used for keys that are not set.
+func Default() *Config {
+	return &Config{
+		Port:              19200,
+		Database:          "device.db",
+		DeviceGroups:      4,
+		MaxDeviceGroups:   16,
+		NatsPortOffset:    1,
+		APIPortOffset:     2,
+		MaxLightsPerGroup: 50,
+		Placement:         "fill-first",
+		SSDPAddress:       "239.255.255.250:1900",
+	}
+}
+
+// NatsPort is the port of the embedded nats server.
+func (c *Config) NatsPort() int {
+	return c.Port + c.NatsPortOffset
+}
+
+// APIPort is the port of the api server of the first device group.
+func (c *Config) APIPort() int {
+	return c.Port + c.APIPortOffset
+}
+
+// key is a setting of the config, named by its dotted file key. The environment variable is the
+// key in upper case with dots replaced by underscores, the flag replaces underscores with dashes.
+type key struct {
+	name    string
+	usage   string
+	str     *string
+	num     *int
+	boolean *bool
+	// list is set from a file list or a comma separated env or flag value.
+	list *[]string
+}
+
+func (k *key) env() string {
+	return strings.ToUpper(strings.Replace(k.name, ".", "_", -1))
+}
+
+func (k *key) flag() string {
+	return strings.Replace(k.name, "_", "-", -1)
+}
+
+func (k *key) set(value string) error {
+	switch {
+	case k.str != nil:
+		*k.str = value
+		return nil
+	case k.boolean != nil:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("config key %s: expected true or false, got %q", k.name, value)
+		}
+		*k.boolean = b
+		return nil
+	case k.list != nil:
+		return k.setList(strings.Split(value, ","))
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("config key %s: expected a number, got %q", k.name, value)
+	}
+	*k.num = n
+	return nil
+}
+
+func (k *key) setList(values []string) error {
+	list := []string{}
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	*k.list = list
+	return nil
+}
+
+func (k *key) String() string {
+	switch {
+	case k.str != nil:
+		return *k.str
+	case k.boolean != nil:
+		return strconv.FormatBool(*k.boolean)
+	case k.list != nil:
+		return strings.Join(*k.list, ",")
+	}
+	return strconv.Itoa(*k.num)
+}
+
+func (c *Config) keys() []*key {
+	return []*key{
+		{name: "ip", usage: "ip address the servers listen on and advertise, detected when empty", str: &c.IP},
+		{name: "interface", usage: "network interface used for ip detection and ssdp discovery", str: &c.Interface},
+		{name: "port", usage: "port of the web app", num: &c.Port},
+		{name: "tls_host", usage: "host name of the web app tls certificate", str: &c.TLSHost},
+		{name: "database", usage: "path of the device database", str: &c.Database},
+		{name: "device_groups", usage: "number of device groups created on the first start", num: &c.DeviceGroups},
+		{name: "max_device_groups", usage: "maximum number of device groups", num: &c.MaxDeviceGroups},
+		{name: "nats_port_offset", usage: "offset of the nats port from port", num: &c.NatsPortOffset},
+		{name: "api_port_offset", usage: "offset of the first api server port from port", num: &c.APIPortOffset},
+		{name: "max_lights_per_group", usage: "lights added to a device group before the next one is used", num: &c.MaxLightsPerGroup},
+		{name: "placement", usage: "device group placement of new lights, fill-first, round-robin or pinned", str: &c.Placement},
+		{name: "placement_group", usage: "device group new lights are pinned to", str: &c.PlacementGroup},
+		{name: "ssdp_address", usage: "multicast address of upnp discovery", str: &c.SSDPAddress},
+		{name: "nats.user", usage: "nats user", str: &c.NATS.User},
+		{name: "nats.password", usage: "nats password", str: &c.NATS.Password},
+		{name: "nats.token", usage: "nats authorization token", str: &c.NATS.Token},
+		{name: "nats.tls_cert", usage: "nats tls certificate file", str: &c.NATS.TLSCert},
+		{name: "nats.tls_key", usage: "nats tls key file", str: &c.NATS.TLSKey},
+		{name: "nats.tls_ca", usage: "nats tls ca file verifying the server and, with nats.tls_verify, client certificates", str: &c.NATS.TLSCA},
+		{name: "nats.tls_verify", usage: "require nats clients to present a certificate signed by nats.tls_ca", boolean: &c.NATS.TLSVerify},
+		{name: "nats.cluster_port", usage: "nats cluster port", num: &c.NATS.ClusterPort},
+		{name: "nats.routes", usage: "comma separated nats cluster routes", str: &c.NATS.Routes},
+		{name: "nats.url", usage: "url of an external nats server used instead of the embedded one", str: &c.NATS.URL},
+		{name: "mqtt.broker", usage: "mqtt broker url, enables the mqtt bridge", str: &c.MQTT.Broker},
+		{name: "mqtt.client_id", usage: "mqtt client id", str: &c.MQTT.ClientID},
+		{name: "mqtt.user", usage: "mqtt user", str: &c.MQTT.User},
+		{name: "mqtt.password", usage: "mqtt password", str: &c.MQTT.Password},
+		{name: "mqtt.prefix", usage: "first level of the mqtt light topics", str: &c.MQTT.Prefix},
+		{name: "commands.enabled", usage: "allow lights to run local programs on state changes", boolean: &c.Commands.Enabled},
+		{name: "commands.allow", usage: "comma separated absolute paths of the programs lights may run", list: &c.Commands.Allow},
+	}
+}
+
+// Flags are the command line flags of the config, registered before the flag set is parsed.
+type Flags struct {
+	file   *string
+	values map[string]*string
+}
+
+// RegisterFlags adds a -config flag and a flag for every key to fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{
+		file:   fs.String("config", "", "yaml, json or toml config file"),
+		values: map[string]*string{},
+	}
+	for _, k := range Default().keys() {
+		usage := fmt.Sprintf("%s, env %s", k.usage, k.env())
+		if value := k.String(); value != "" && value != "0" && value != "false" {
+			usage += ", default " + value
+		}
+		f.values[k.name] = fs.String(k.flag(), "", usage)
+	}
+	return f
+}
+
+// Load builds the config from the defaults, the config file, the environment and the flags that
+// were set, then validates it.
+func Load(fs *flag.FlagSet, f *Flags) (c *Config, err error) {
+	c = Default()
+
+	file := os.Getenv("CONFIG")
+	if f != nil && *f.file != "" {
+		file = *f.file
+	}
+	if file == "" {
+		for _, name := range DefaultFiles {
+			if _, statErr := os.Stat(name); statErr == nil {
+				file = name
+				break
+			}
+		}
+	}
+	if file != "" {
+		if err = c.load(file); err != nil {
+			return nil, err
+		}
+	}
+
+	set := map[string]bool{}
+	if fs != nil {
+		fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+	}
+	for _, k := range c.keys() {
+		if value, ok := os.LookupEnv(k.env()); ok {
+			if err = k.set(value); err != nil {
+				return nil, err
+			}
+		}
+		if f != nil && set[k.flag()] {
+			if err = k.set(*f.values[k.name]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err = c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads a config file, the format is chosen by the file extension.
+func (c *Config) load(file string) (err error) {
+	var b []byte
+	if b, err = os.ReadFile(file); err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &values)
+	case ".json":
+		err = json.Unmarshal(b, &values)
+	case ".toml":
+		err = toml.Unmarshal(b, &values)
+	default:
+		return fmt.Errorf("config file %s: unknown format, use .yaml, .json or .toml", file)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %v", file, err)
+	}
+
+	flat := map[string]interface{}{}
+	flatten("", values, flat)
+
+	keys := map[string]*key{}
+	for _, k := range c.keys() {
+		keys[k.name] = k
+	}
+	names := make([]string, 0, len(flat))
+	for name := range flat {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		k, ok := keys[name]
+		if !ok {
+			return fmt.Errorf("config file %s: unknown key %s", file, name)
+		}
+		switch v := flat[name].(type) {
+		case []interface{}:
+			if k.list == nil {
+				return fmt.Errorf("config file %s: key %s must be a single value", file, name)
+			}
+			values := make([]string, 0, len(v))
+			for _, item := range v {
+				values = append(values, fmt.Sprint(item))
+			}
+			k.setList(values)
+		case map[string]interface{}:
+			return fmt.Errorf("config file %s: key %s must be a single value", file, name)
+		case nil:
+		default:
+			if err = k.set(fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("config file %s: %v", file, err)
+			}
+		}
+	}
+	return nil
+}
+
+// flatten turns nested tables into dotted keys, {"nats": {"user": "x"}} becomes nats.user.
+func flatten(prefix string, values map[string]interface{}, flat map[string]interface{}) {
+	for name, value := range values {
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			flatten(name, nested, flat)
+			continue
+		}
+		flat[name] = value
+	}
+}
+
+// Validate checks every key, the error names the first key with a bad value.
+func (c *Config) Validate() error {
+	if c.IP != "" && net.ParseIP(c.IP).To4() == nil {
+		return fmt.Errorf("config key ip: %q is not an ipv4 address", c.IP)
+	}
+	lastPort := c.APIPort() + c.MaxDeviceGroups - 1
+	switch {
+	case c.Port < 1 || c.Port > 65535:
+		return fmt.Errorf("config key port: %d is not a valid port", c.Port)
+	case c.Database == "":
+		return fmt.Errorf("config key database: required")
+	case c.DeviceGroups < 1:
+		return fmt.Errorf("config key device_groups: must be at least 1")
+	case c.NatsPortOffset < 1:
+		return fmt.Errorf("config key nats_port_offset: must be at least 1")
+	case c.APIPortOffset < 1:
+		return fmt.Errorf("config key api_port_offset: must be at least 1")
+	case c.MaxDeviceGroups < c.DeviceGroups:
+		return fmt.Errorf("config key max_device_groups: must be at least device_groups %d", c.DeviceGroups)
+	case c.NatsPortOffset >= c.APIPortOffset && c.NatsPortOffset <= c.APIPortOffset+c.MaxDeviceGroups-1:
+		return fmt.Errorf("config key nats_port_offset: nats port %d overlaps the api server ports", c.NatsPort())
+	case c.NatsPort() > 65535:
+		return fmt.Errorf("config key nats_port_offset: nats port %d is not a valid port", c.NatsPort())
+	case lastPort > 65535:
+		return fmt.Errorf("config key max_device_groups: api server port %d is not a valid port", lastPort)
+	case c.MaxLightsPerGroup < 1:
+		return fmt.Errorf("config key max_lights_per_group: must be at least 1")
+	case c.Placement != "fill-first" && c.Placement != "round-robin" && c.Placement != "pinned":
+		return fmt.Errorf("config key placement: %q must be fill-first, round-robin or pinned", c.Placement)
+	case c.Placement == "pinned" && c.PlacementGroup == "":
+		return fmt.Errorf("config key placement_group: required when placement is pinned")
+	case c.NATS.ClusterPort < 0 || c.NATS.ClusterPort > 65535:
+		return fmt.Errorf("config key nats.cluster_port: %d is not a valid port", c.NATS.ClusterPort)
+	}
+
+	if addr, err := net.ResolveUDPAddr("udp4", c.SSDPAddress); err != nil {
+		return fmt.Errorf("config key ssdp_address: %v", err)
+	} else if !addr.IP.IsMulticast() {
+		return fmt.Errorf("config key ssdp_address: %s is not a multicast address", c.SSDPAddress)
+	}
+
+	if c.NATS.URL != "" {
+		if _, err := url.Parse(c.NATS.URL); err != nil {
+			return fmt.Errorf("config key nats.url: %v", err)
+		}
+	}
+	if err := c.NATS.Validate(); err != nil {
+		return fmt.Errorf("config key nats: %v", err)
+	}
+	if c.MQTT.Broker != "" {
+		if u, err := url.Parse(c.MQTT.Broker); err != nil {
+			return fmt.Errorf("config key mqtt.broker: %v", err)
+		} else if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("config key mqtt.broker: %q must be a url such as tcp://host:1883", c.MQTT.Broker)
+		}
+	}
+	for _, path := range c.Commands.Allow {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("config key commands.allow: %q is not an absolute path", path)
+		}
+	}
+	if c.Commands.Enabled && len(c.Commands.Allow) == 0 {
+		return fmt.Errorf("config key commands.allow: required when commands.enabled is true")
+	}
+	return nil
+}
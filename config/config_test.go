@@ -0,0 +1,246 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// clearEnv unsets the environment variables of every key for the duration of the test.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	names := []string{"CONFIG"}
+	for _, k := range Default().keys() {
+		names = append(names, k.env())
+	}
+	for _, name := range names {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func load(t *testing.T, args ...string) (*Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("vhugo", flag.ContinueOnError)
+	f := RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return Load(fs, f)
+}
+
+func TestLoadFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"vhugo.yaml", `
+port: 20000
+device_groups: 2
+placement: round-robin
+nats:
+  token: secret
+  tls_verify: false
+commands:
+  enabled: true
+  allow:
+    - /usr/local/bin/relay
+    - /bin/echo
+`},
+		{"vhugo.json", `{
+  "port": 20000,
+  "device_groups": 2,
+  "placement": "round-robin",
+  "nats": {"token": "secret", "tls_verify": false},
+  "commands": {"enabled": true, "allow": ["/usr/local/bin/relay", "/bin/echo"]}
+}`},
+		{"vhugo.toml", `
+port = 20000
+device_groups = 2
+placement = "round-robin"
+
+[nats]
+token = "secret"
+tls_verify = false
+
+[commands]
+enabled = true
+allow = ["/usr/local/bin/relay", "/bin/echo"]
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			c, err := load(t, "-config", writeFile(t, tt.name, tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Port != 20000 || c.DeviceGroups != 2 || c.Placement != "round-robin" || c.NATS.Token != "secret" {
+				t.Errorf("unexpected config %+v", c)
+			}
+			if !c.Commands.Enabled || !reflect.DeepEqual(c.Commands.Allow, []string{"/usr/local/bin/relay", "/bin/echo"}) {
+				t.Errorf("unexpected commands %+v", c.Commands)
+			}
+			// keys missing from the file keep their defaults
+			if c.MaxLightsPerGroup != Default().MaxLightsPerGroup || c.Database != Default().Database {
+				t.Errorf("defaults not kept %+v", c)
+			}
+		})
+	}
+}
+
+func TestLoadFileFromEnv(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CONFIG", writeFile(t, "vhugo.yaml", "port: 20000\n"))
+	c, err := load(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != 20000 {
+		t.Errorf("port = %d, want 20000", c.Port)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t)
+	file := writeFile(t, "vhugo.yaml", "port: 20000\ndevice_groups: 2\nmax_lights_per_group: 10\nnats:\n  user: file\n  password: secret\n")
+
+	t.Setenv("PORT", "21000")
+	t.Setenv("MAX_LIGHTS_PER_GROUP", "20")
+	t.Setenv("NATS_USER", "env")
+	t.Setenv("COMMANDS_ALLOW", "/bin/true, /bin/false")
+	c, err := load(t, "-config", file, "-max-lights-per-group", "30", "-nats.tls-verify=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.DeviceGroups != 2 {
+		t.Errorf("device_groups = %d, want 2 from the file", c.DeviceGroups)
+	}
+	if c.Port != 21000 || c.NATS.User != "env" {
+		t.Errorf("port = %d, nats.user = %s, want the env values", c.Port, c.NATS.User)
+	}
+	if c.MaxLightsPerGroup != 30 {
+		t.Errorf("max_lights_per_group = %d, want 30 from the flag", c.MaxLightsPerGroup)
+	}
+	if !reflect.DeepEqual(c.Commands.Allow, []string{"/bin/true", "/bin/false"}) {
+		t.Errorf("commands.allow = %v, want the env list", c.Commands.Allow)
+	}
+}
+
+func TestLoadInvalidValues(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"env number", map[string]string{"PORT": "http"}, nil, "config key port: expected a number"},
+		{"env bool", map[string]string{"COMMANDS_ENABLED": "yes please"}, nil, "config key commands.enabled: expected true or false"},
+		{"flag number", nil, []string{"-device-groups", "two"}, "config key device_groups: expected a number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			if _, err := load(t, tt.args...); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{"unknown key", "vhugo.yaml", "colour: blue\n", "unknown key colour"},
+		{"unknown nested key", "vhugo.yaml", "nats:\n  usr: x\n", "unknown key nats.usr"},
+		{"unknown json key", "vhugo.json", `{"mqtt": {"topic": "x"}}`, "unknown key mqtt.topic"},
+		{"unknown toml key", "vhugo.toml", "[commands]\nrun = true\n", "unknown key commands.run"},
+		{"list for a single value", "vhugo.yaml", "port: [1, 2]\n", "key port must be a single value"},
+		{"table for a single value", "vhugo.yaml", "ip:\n  v4: 10.0.0.1\n", "unknown key ip.v4"},
+		{"wrong type", "vhugo.yaml", "port: http\n", "config key port: expected a number"},
+		{"invalid syntax", "vhugo.json", `{"port": `, "config file"},
+		{"unknown format", "vhugo.ini", "port=1\n", "unknown format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			if _, err := load(t, "-config", writeFile(t, tt.file, tt.content)); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	clearEnv(t)
+	if _, err := load(t, "-config", filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(c *Config)
+		want string
+	}{
+		{"ip", func(c *Config) { c.IP = "::1" }, "config key ip:"},
+		{"port", func(c *Config) { c.Port = 0 }, "config key port:"},
+		{"database", func(c *Config) { c.Database = "" }, "config key database:"},
+		{"device_groups", func(c *Config) { c.DeviceGroups = 0 }, "config key device_groups:"},
+		{"nats_port_offset", func(c *Config) { c.NatsPortOffset = 0 }, "config key nats_port_offset: must be"},
+		{"api_port_offset", func(c *Config) { c.APIPortOffset = 0 }, "config key api_port_offset:"},
+		{"max_device_groups", func(c *Config) { c.MaxDeviceGroups = 2 }, "config key max_device_groups: must be"},
+		{"nats port overlap", func(c *Config) { c.NatsPortOffset = 5 }, "overlaps the api server ports"},
+		{"nats port range", func(c *Config) { c.Port = 65535 }, "config key nats_port_offset: nats port 65536"},
+		{"api port range", func(c *Config) { c.Port = 65530 }, "config key max_device_groups: api server port"},
+		{"max_lights_per_group", func(c *Config) { c.MaxLightsPerGroup = 0 }, "config key max_lights_per_group:"},
+		{"placement", func(c *Config) { c.Placement = "random" }, "config key placement:"},
+		{"placement_group", func(c *Config) { c.Placement = "pinned" }, "config key placement_group:"},
+		{"nats.cluster_port", func(c *Config) { c.NATS.ClusterPort = 70000 }, "config key nats.cluster_port:"},
+		{"ssdp_address", func(c *Config) { c.SSDPAddress = "239.255.255.250" }, "config key ssdp_address:"},
+		{"ssdp_address multicast", func(c *Config) { c.SSDPAddress = "192.168.1.1:1900" }, "is not a multicast address"},
+		{"nats.url", func(c *Config) { c.NATS.URL = ":4222" }, "config key nats.url:"},
+		{"nats", func(c *Config) { c.NATS.Token, c.NATS.User = "t", "u" }, "config key nats:"},
+		{"nats tls verify", func(c *Config) { c.NATS.TLSVerify = true }, "config key nats: nats tls verify"},
+		{"nats cluster auth", func(c *Config) { c.NATS.ClusterPort = 6222 }, "nats cluster requires the nats user and password"},
+		{"nats cluster token", func(c *Config) { c.NATS.Routes, c.NATS.Token = "nats-route://10.0.0.2:6222", "t" }, "routes do not accept a token"},
+		{"nats cluster tls", func(c *Config) {
+			c.NATS.ClusterPort, c.NATS.User, c.NATS.Password = 6222, "u", "p"
+			c.NATS.TLSCert, c.NATS.TLSKey = "cert.pem", "key.pem"
+		}, "nats cluster with tls requires the tls ca"},
+		{"mqtt.broker", func(c *Config) { c.MQTT.Broker = "localhost:1883" }, "config key mqtt.broker:"},
+		{"commands.allow", func(c *Config) { c.Commands.Allow = []string{"relay"} }, "config key commands.allow: \"relay\""},
+		{"commands.enabled", func(c *Config) { c.Commands.Enabled = true }, "config key commands.allow: required"},
+	}
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("defaults do not validate: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.edit(c)
+			if err := c.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package devicedb
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+)
+
+// MaxDeliveries is the number of deliveries kept in the delivery log of a light.
+const MaxDeliveries = 25
+
+// Delivery is the outcome of an action, such as a webhook or command, run for a state change of a light.
+// Status is the http status of a webhook or the exit code of a command.
+type Delivery struct {
+	Timestamp string `json:"timestamp"`
+	Action    string `json:"action"`
+	Target    string `json:"target"`
+	Attempts  int    `json:"attempts"`
+	Status    int    `json:"status,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Output    string `json:"output,omitempty"`
+	Duration  int64  `json:"duration"`
+}
+
+func (d *DeviceDB) deliveriesUpdate(groupID string, fn func(dlBucket *bolt.Bucket) error) error {
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(groupID + "_deliveries"))
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+// GetDeliveries returns the delivery log of a light, the most recent delivery first.
+func (d *DeviceDB) GetDeliveries(groupID string, lightID string) (deliveries []*Delivery, err error) {
+	deliveries = make([]*Delivery, 0)
+	err = d.deliveriesUpdate(groupID, func(dlBucket *bolt.Bucket) error {
+		if dlBytes := dlBucket.Get([]byte(lightID)); dlBytes != nil {
+			return json.Unmarshal(dlBytes, &deliveries)
+		}
+		return nil
+	})
+	return
+}
+
+// AddDelivery records a delivery in the log of a light, dropping the oldest beyond MaxDeliveries.
+func (d *DeviceDB) AddDelivery(groupID string, lightID string, delivery *Delivery) error {
+	return d.deliveriesUpdate(groupID, func(dlBucket *bolt.Bucket) error {
+		deliveries := make([]*Delivery, 0)
+		if dlBytes := dlBucket.Get([]byte(lightID)); dlBytes != nil {
+			if err := json.Unmarshal(dlBytes, &deliveries); err != nil {
+				return err
+			}
+		}
+		deliveries = append([]*Delivery{delivery}, deliveries...)
+		if len(deliveries) > MaxDeliveries {
+			deliveries = deliveries[:MaxDeliveries]
+		}
+		if dlBytes, err := json.Marshal(deliveries); err != nil {
+			return err
+		} else {
+			return dlBucket.Put([]byte(lightID), dlBytes)
+		}
+	})
+}
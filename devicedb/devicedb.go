@@ -2,11 +2,10 @@ package devicedb
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
@@ -51,6 +50,28 @@ func NewDeviceGroup(groupID string) *DeviceGroup {
 	}
 }
 
+// Mac returns a mac address derived from the group uuid, used to identify the emulated bridge.
+func (dg *DeviceGroup) Mac() string {
+	parts := make([]string, 0, 6)
+	for i := 0; i+2 <= len(dg.UU); i += 2 {
+		parts = append(parts, dg.UU[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// BridgeID returns the hue bridge id, which is the mac address with FFFE inserted in the middle.
+func (dg *DeviceGroup) BridgeID() string {
+	if len(dg.UU) != 12 {
+		return strings.ToUpper(dg.UU)
+	}
+	return strings.ToUpper(dg.UU[:6] + "fffe" + dg.UU[6:])
+}
+
+// Name returns the bridge name, which is also the friendly name in the setup xml.
+func (dg *DeviceGroup) Name() string {
+	return "VHugo " + dg.UU
+}
+
 func (dg *DeviceGroup) Setup() (setupXml []byte, err error) {
 	buf := &bytes.Buffer{}
 	if err = tmpl.SettingsTemplate.Execute(buf, dg); err == nil {
@@ -102,6 +123,20 @@ func (d *DeviceDB) AddDeviceGroup(dg *DeviceGroup) error {
 	})
 }
 
+// UpdateDeviceGroup stores the changed settings of an existing device group, such as its server ip.
+func (d *DeviceDB) UpdateDeviceGroup(dg *DeviceGroup) error {
+	return d.deviceGroupsUpdate(func(dgBucket *bolt.Bucket) error {
+		if dgBucket.Get([]byte(dg.GroupID)) == nil {
+			return fmt.Errorf("device group %s does not exist", dg.GroupID)
+		}
+		if dgBytes, err := json.Marshal(dg); err != nil {
+			return err
+		} else {
+			return dgBucket.Put([]byte(dg.GroupID), dgBytes)
+		}
+	})
+}
+
 func (d *DeviceDB) GetDeviceGroup(groupID string) (dg *DeviceGroup, err error) {
 	err = d.deviceGroupsUpdate(func(dgBucket *bolt.Bucket) error {
 		dgBytes := dgBucket.Get([]byte(groupID))
@@ -115,33 +150,48 @@ func (d *DeviceDB) GetDeviceGroup(groupID string) (dg *DeviceGroup, err error) {
 	return
 }
 
+// DeleteDeviceGroup removes the device group along with its lights, groups, scenes and other buckets.
 func (d *DeviceDB) DeleteDeviceGroup(groupID string) error {
 	return d.deviceGroupsUpdate(func(dgBucket *bolt.Bucket) error {
 		if dgBucket.Get([]byte(groupID)) == nil {
 			return fmt.Errorf("device group %s does not exist", groupID)
-		} else {
-			return dgBucket.Delete([]byte(groupID))
 		}
+		tx := dgBucket.Tx()
+		prefix := []byte(groupID + "_")
+		var names [][]byte
+		err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if bytes.HasPrefix(name, prefix) {
+				names = append(names, append([]byte{}, name...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err = tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return dgBucket.Delete([]byte(groupID))
 	})
 }
 
-func NewVirtualLight(name string) *VirtualLight {
-	return &VirtualLight{
+// NewVirtualLight creates a light of the given hue light type, nil when the type is unknown.
+func NewVirtualLight(name string, lightType string) *VirtualLight {
+	p := GetLightProfile(lightType)
+	if p == nil {
+		return nil
+	}
+	vl := &VirtualLight{
 		State: VirtualLightState{
 			On:        false,
-			Bri:       0,
-			Hue:       13088,
-			Sat:       212,
-			Xy:        []float32{0.5128, 0.4147},
-			Ct:        467,
 			Alert:     "none",
-			Effect:    "none",
-			Colormode: "xy",
 			Reachable: true,
 		},
-		Type:      "Extended color light",
+		Type:      p.Type,
 		Name:      name,
-		Modelid:   "LCT001",
+		Modelid:   p.ModelID,
 		Swversion: "66009461",
 		Pointsymbol: map[string]string{
 			"1": "none",
@@ -154,6 +204,32 @@ func NewVirtualLight(name string) *VirtualLight {
 			"8": "none",
 		},
 	}
+	if p.Dimmable {
+		vl.State.Bri = MaxBri
+	}
+	if p.ColorTemperature {
+		vl.State.Ct = 467
+		vl.State.Colormode = "ct"
+	}
+	if p.Color {
+		vl.State.Hue = 13088
+		vl.State.Sat = 212
+		vl.State.Xy = []float32{0.5128, 0.4147}
+		vl.State.Effect = "none"
+		vl.State.Colormode = "xy"
+	}
+	return vl
+}
+
+// MaxLightNameLength is the longest light name a hue bridge accepts.
+const MaxLightNameLength = 32
+
+// LightAttributeChange is published on the lightAttributeChange subject when a light is renamed or edited.
+type LightAttributeChange struct {
+	GroupID     string            `json:"groupID"`
+	LightID     string            `json:"lightID"`
+	Name        string            `json:"name"`
+	Pointsymbol map[string]string `json:"pointsymbol"`
 }
 
 type VirtualLight struct {
@@ -165,13 +241,9 @@ type VirtualLight struct {
 	Pointsymbol map[string]string `json:"pointsymbol"`
 }
 
-func (vl *VirtualLight) UpdateState(sr *StateRequest) {
-	if sr.On != nil {
-		vl.State.On = *sr.On
-	}
-	if sr.Bri != nil {
-		vl.State.Bri = *sr.Bri
-	}
+// ValidLightName reports if name can be used as the name of a light.
+func ValidLightName(name string) bool {
+	return name != "" && len(name) <= MaxLightNameLength
 }
 
 func (d *DeviceDB) virtualLightsUpdate(groupID string, fn func(vlBucket *bolt.Bucket) error) error {
@@ -217,31 +289,85 @@ func (d *DeviceDB) GetVirtualLight(groupID string, lightID string) (virtualLight
 	return
 }
 
+// DeleteVirtualLight removes a light with its options and delivery log in a single transaction, dropping it
+// from the hue groups and scenes of the group along with the schedules and rules that refer to it.
 func (d *DeviceDB) DeleteVirtualLight(groupID string, lightID string) error {
-	return d.virtualLightsUpdate(groupID, func(vlBucket *bolt.Bucket) error {
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		vlBucket, err := tx.CreateBucketIfNotExists([]byte(groupID + "_virtualLights"))
+		if err != nil {
+			return err
+		}
 		key := []byte(lightID)
 		if vlBucket.Get(key) == nil {
 			return fmt.Errorf("virtual light %s does not exist in group %s", lightID, groupID)
-		} else {
-			return vlBucket.Delete(key)
 		}
+		if err = vlBucket.Delete(key); err != nil {
+			return err
+		}
+		for _, suffix := range []string{"_lightOptions", "_deliveries"} {
+			if b := tx.Bucket([]byte(groupID + suffix)); b != nil {
+				if err = b.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		return removeLightReferences(tx, groupID, lightID)
+	})
+}
+
+// AddVirtualLight stores a new virtual light and returns the small integer id allocated for it.
+// The light is reported as new by the next search for new lights.
+func (d *DeviceDB) AddVirtualLight(groupID string, virtualLight *VirtualLight) (lightID string, err error) {
+	err = d.virtualLightsUpdate(groupID, func(vlBucket *bolt.Bucket) error {
+		seq, err := vlBucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		lightID = strconv.FormatUint(seq, 10)
+		if vlBytes, err := json.Marshal(virtualLight); err != nil {
+			return err
+		} else if err = vlBucket.Put([]byte(lightID), vlBytes); err != nil {
+			return err
+		}
+		return recordNewLight(vlBucket.Tx(), groupID, lightID)
 	})
+	return
 }
 
-func (d *DeviceDB) UpdateVirtualLight(groupID string, virtualLight *VirtualLight) (err error) {
+func (d *DeviceDB) UpdateVirtualLight(groupID string, lightID string, virtualLight *VirtualLight) error {
 	return d.virtualLightsUpdate(groupID, func(vlBucket *bolt.Bucket) error {
-		if vlBytes, errMarshal := json.Marshal(virtualLight); err != nil {
-			return errMarshal
+		if vlBucket.Get([]byte(lightID)) == nil {
+			return fmt.Errorf("virtual light %s does not exist in group %s", lightID, groupID)
+		}
+		if vlBytes, err := json.Marshal(virtualLight); err != nil {
+			return err
 		} else {
-			return vlBucket.Put([]byte(Sha(virtualLight.Name)), vlBytes)
+			return vlBucket.Put([]byte(lightID), vlBytes)
 		}
 	})
 }
 
-func Sha(name string) string {
-	hash := sha256.New()
-	io.WriteString(hash, name)
-	return fmt.Sprintf("%x", hash.Sum(nil))
+// EditVirtualLight applies fn to the stored light within a single transaction so concurrent state changes are not lost.
+func (d *DeviceDB) EditVirtualLight(groupID string, lightID string, fn func(vl *VirtualLight) error) (virtualLight *VirtualLight, err error) {
+	err = d.virtualLightsUpdate(groupID, func(vlBucket *bolt.Bucket) error {
+		vlBytes := vlBucket.Get([]byte(lightID))
+		if vlBytes == nil {
+			return fmt.Errorf("virtual light %s does not exist in group %s", lightID, groupID)
+		}
+		virtualLight = &VirtualLight{}
+		if err := json.Unmarshal(vlBytes, virtualLight); err != nil {
+			return err
+		}
+		if err := fn(virtualLight); err != nil {
+			return err
+		}
+		if vlBytes, err := json.Marshal(virtualLight); err != nil {
+			return err
+		} else {
+			return vlBucket.Put([]byte(lightID), vlBytes)
+		}
+	})
+	return
 }
 
 type VirtualLightState struct {
@@ -256,8 +382,3 @@ type VirtualLightState struct {
 	Colormode string    `json:"colormode"`
 	Reachable bool      `json:"reachable"`
 }
-
-type StateRequest struct {
-	On  *bool  `json:"on"`
-	Bri *int32 `json:"bri"`
-}
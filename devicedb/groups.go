@@ -0,0 +1,253 @@
+package devicedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// AllLightsGroup is the hue group id which always contains every light.
+const AllLightsGroup = "0"
+
+type HueGroupState struct {
+	AllOn bool `json:"all_on"`
+	AnyOn bool `json:"any_on"`
+}
+
+// HueGroup is a hue api group or room of lights within a device group.
+type HueGroup struct {
+	Name   string            `json:"name"`
+	Lights []string          `json:"lights"`
+	Type   string            `json:"type"`
+	Class  string            `json:"class,omitempty"`
+	State  HueGroupState     `json:"state"`
+	Action VirtualLightState `json:"action"`
+}
+
+// RoomClasses are the classes a hue room can have.
+var RoomClasses = []string{
+	"Living room", "Kitchen", "Dining", "Bedroom", "Kids bedroom", "Bathroom", "Nursery", "Recreation",
+	"Office", "Gym", "Hallway", "Toilet", "Front door", "Garage", "Terrace", "Garden", "Driveway",
+	"Carport", "Other", "Home", "Downstairs", "Upstairs", "Top floor", "Attic", "Guest room",
+	"Staircase", "Lounge", "Man cave", "Computer", "Studio", "Music", "TV", "Reading", "Closet",
+	"Storage", "Laundry room", "Balcony", "Porch", "Barbecue", "Pool",
+}
+
+// ValidRoomClass is true for a class in RoomClasses.
+func ValidRoomClass(class string) bool {
+	for _, c := range RoomClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func NewHueGroup(name string, groupType string, lights []string) *HueGroup {
+	if groupType == "" {
+		groupType = "LightGroup"
+	}
+	hg := &HueGroup{Name: name, Type: groupType, Lights: lights}
+	hg.Action = NewVirtualLight("", ExtendedColorLight).State
+	if groupType == "Room" {
+		hg.Class = "Other"
+	}
+	return hg
+}
+
+// UpdateAction records sr as the last action applied to the group.
+func (hg *HueGroup) UpdateAction(sr *StateRequest) {
+	vl := &VirtualLight{State: hg.Action}
+	vl.UpdateState(sr)
+	hg.Action = vl.State
+}
+
+// UpdateGroupState computes the all_on and any_on state from the provided member lights.
+func (hg *HueGroup) UpdateGroupState(lights map[string]*VirtualLight) {
+	hg.State = HueGroupState{AllOn: len(hg.Lights) > 0}
+	for _, lightID := range hg.Lights {
+		if vl, ok := lights[lightID]; ok && vl.State.On {
+			hg.State.AnyOn = true
+		} else {
+			hg.State.AllOn = false
+		}
+	}
+}
+
+func (d *DeviceDB) hueGroupsUpdate(groupID string, fn func(hgBucket *bolt.Bucket) error) error {
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(groupID + "_hueGroups"))
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+// IDLess orders the integer ids of lights, groups, scenes and rules numerically, 2 before 10.
+func IDLess(a string, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func (d *DeviceDB) allLightsGroupUpdate(groupID string, fn func(alBucket *bolt.Bucket) error) error {
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(groupID + "_allLightsGroup"))
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+// AllLightsHueGroup returns the special group 0 with every light of the device group as members in
+// id order. Only the last action of group 0 is stored, the members and state follow the lights.
+func (d *DeviceDB) AllLightsHueGroup(groupID string) (hg *HueGroup, err error) {
+	lights, err := d.GetVirtualLights(groupID)
+	if err != nil {
+		return
+	}
+	lightIDs := make([]string, 0, len(lights))
+	for lightID := range lights {
+		lightIDs = append(lightIDs, lightID)
+	}
+	sort.Slice(lightIDs, func(i, j int) bool { return IDLess(lightIDs[i], lightIDs[j]) })
+	hg = NewHueGroup("Group 0", "LightGroup", lightIDs)
+	hg.UpdateGroupState(lights)
+	err = d.allLightsGroupUpdate(groupID, func(alBucket *bolt.Bucket) error {
+		if actionBytes := alBucket.Get([]byte("action")); actionBytes != nil {
+			return json.Unmarshal(actionBytes, &hg.Action)
+		}
+		return nil
+	})
+	return
+}
+
+func (d *DeviceDB) GetHueGroups(groupID string) (hueGroups map[string]*HueGroup, err error) {
+	hueGroups = make(map[string]*HueGroup)
+
+	err = d.hueGroupsUpdate(groupID, func(hgBucket *bolt.Bucket) error {
+		c := hgBucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			hg := &HueGroup{}
+			if err = json.Unmarshal(v, hg); err == nil {
+				hueGroups[string(k)] = hg
+			} else {
+				if err := hgBucket.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (d *DeviceDB) GetHueGroup(groupID string, hueGroupID string) (hg *HueGroup, err error) {
+	if hueGroupID == AllLightsGroup {
+		return d.AllLightsHueGroup(groupID)
+	}
+	err = d.hueGroupsUpdate(groupID, func(hgBucket *bolt.Bucket) error {
+		hgBytes := hgBucket.Get([]byte(hueGroupID))
+		if hgBytes == nil {
+			return fmt.Errorf("hue group %s does not exist in group %s", hueGroupID, groupID)
+		} else {
+			hg = &HueGroup{}
+			return json.Unmarshal(hgBytes, hg)
+		}
+	})
+	return
+}
+
+// AddHueGroup stores a new hue group and returns the id allocated for it. A group without a
+// name is named after its id.
+func (d *DeviceDB) AddHueGroup(groupID string, hg *HueGroup) (hueGroupID string, err error) {
+	err = d.hueGroupsUpdate(groupID, func(hgBucket *bolt.Bucket) error {
+		seq, err := hgBucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		hueGroupID = strconv.FormatUint(seq, 10)
+		if hg.Name == "" {
+			hg.Name = "Group " + hueGroupID
+		}
+		if hgBytes, err := json.Marshal(hg); err != nil {
+			return err
+		} else {
+			return hgBucket.Put([]byte(hueGroupID), hgBytes)
+		}
+	})
+	return
+}
+
+func (d *DeviceDB) UpdateHueGroup(groupID string, hueGroupID string, hg *HueGroup) error {
+	if hueGroupID == AllLightsGroup {
+		// group 0 is computed from the lights, only its action is stored
+		return d.allLightsGroupUpdate(groupID, func(alBucket *bolt.Bucket) error {
+			if actionBytes, err := json.Marshal(hg.Action); err != nil {
+				return err
+			} else {
+				return alBucket.Put([]byte("action"), actionBytes)
+			}
+		})
+	}
+	return d.hueGroupsUpdate(groupID, func(hgBucket *bolt.Bucket) error {
+		if hgBucket.Get([]byte(hueGroupID)) == nil {
+			return fmt.Errorf("hue group %s does not exist in group %s", hueGroupID, groupID)
+		}
+		if hgBytes, err := json.Marshal(hg); err != nil {
+			return err
+		} else {
+			return hgBucket.Put([]byte(hueGroupID), hgBytes)
+		}
+	})
+}
+
+func (d *DeviceDB) DeleteHueGroup(groupID string, hueGroupID string) error {
+	return d.hueGroupsUpdate(groupID, func(hgBucket *bolt.Bucket) error {
+		key := []byte(hueGroupID)
+		if hgBucket.Get(key) == nil {
+			return fmt.Errorf("hue group %s does not exist in group %s", hueGroupID, groupID)
+		} else {
+			return hgBucket.Delete(key)
+		}
+	})
+}
+
+// removeLightFromHueGroups drops lightID from the member list of every hue group in the bucket.
+func removeLightFromHueGroups(hgBucket *bolt.Bucket, lightID string) error {
+	updates := make(map[string][]byte)
+	c := hgBucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		hg := &HueGroup{}
+		if err := json.Unmarshal(v, hg); err != nil {
+			continue
+		}
+		lights := make([]string, 0, len(hg.Lights))
+		for _, id := range hg.Lights {
+			if id != lightID {
+				lights = append(lights, id)
+			}
+		}
+		if len(lights) == len(hg.Lights) {
+			continue
+		}
+		hg.Lights = lights
+		if hgBytes, err := json.Marshal(hg); err != nil {
+			return err
+		} else {
+			updates[string(k)] = hgBytes
+		}
+	}
+	for k, v := range updates {
+		if err := hgBucket.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
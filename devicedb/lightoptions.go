@@ -0,0 +1,96 @@
+package devicedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// DefaultAckTimeout is how long a light that acknowledges commands is waited on when no timeout is set.
+const DefaultAckTimeout = 2 * time.Second
+
+// LightOptions are the vhugo settings of a light that are not part of the hue light resource.
+type LightOptions struct {
+	// Acknowledge sends state changes as a nats request, a light that does not reply becomes unreachable.
+	Acknowledge bool `json:"acknowledge"`
+	// AckTimeout is the reply timeout in milliseconds, DefaultAckTimeout when zero.
+	AckTimeout int `json:"ackTimeout"`
+	// Webhooks are http requests made on every state change of the light.
+	Webhooks []*Webhook `json:"webhooks,omitempty"`
+	// Commands are local programs run on state changes of the light.
+	Commands []*LightCommand `json:"commands,omitempty"`
+}
+
+// Webhook is an http request made on a state change. URL and Body are text/template templates
+// executed with the state change event, such as {{if .State.On}}on{{else}}off{{end}}.
+type Webhook struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Body    string            `json:"body,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timeout of a single attempt in milliseconds, Retries is the number of attempts after the first.
+	Timeout int `json:"timeout,omitempty"`
+	Retries int `json:"retries,omitempty"`
+}
+
+// LightCommand is a local program run on a state change. Args are text/template templates executed
+// with the state change event, such as {{.State.Bri}}. When limits the command to changes that
+// turn the light "on" or "off", an empty When runs it on every change.
+type LightCommand struct {
+	When string   `json:"when,omitempty"`
+	Path string   `json:"path"`
+	Args []string `json:"args,omitempty"`
+	// Timeout in milliseconds after which the command is killed.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// Timeout is the time to wait for the acknowledgment of a state change, zero when none is expected.
+func (lo *LightOptions) Timeout() time.Duration {
+	switch {
+	case !lo.Acknowledge:
+		return 0
+	case lo.AckTimeout <= 0:
+		return DefaultAckTimeout
+	}
+	return time.Duration(lo.AckTimeout) * time.Millisecond
+}
+
+func (d *DeviceDB) lightOptionsUpdate(groupID string, fn func(loBucket *bolt.Bucket) error) error {
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(groupID + "_lightOptions"))
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+// GetLightOptions returns the options of the light, the zero options when none were set.
+func (d *DeviceDB) GetLightOptions(groupID string, lightID string) (lo *LightOptions, err error) {
+	lo = &LightOptions{}
+	err = d.lightOptionsUpdate(groupID, func(loBucket *bolt.Bucket) error {
+		if loBytes := loBucket.Get([]byte(lightID)); loBytes != nil {
+			return json.Unmarshal(loBytes, lo)
+		}
+		return nil
+	})
+	return
+}
+
+func (d *DeviceDB) UpdateLightOptions(groupID string, lightID string, lo *LightOptions) error {
+	if _, err := d.GetVirtualLight(groupID, lightID); err != nil {
+		return err
+	}
+	if lo.AckTimeout < 0 {
+		return fmt.Errorf("invalid ack timeout %d", lo.AckTimeout)
+	}
+	return d.lightOptionsUpdate(groupID, func(loBucket *bolt.Bucket) error {
+		if loBytes, err := json.Marshal(lo); err != nil {
+			return err
+		} else {
+			return loBucket.Put([]byte(lightID), loBytes)
+		}
+	})
+}
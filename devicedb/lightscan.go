@@ -0,0 +1,94 @@
+package devicedb
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// LightScanDuration is how long a search for new lights started by a hue client stays active.
+const LightScanDuration = 40 * time.Second
+
+// LightScan tracks the lights added to a device group since the last search for new lights.
+type LightScan struct {
+	Started time.Time `json:"started"`
+	Lights  []string  `json:"lights"`
+	Pending []string  `json:"pending"`
+}
+
+// Active is true while the last search is running.
+func (ls *LightScan) Active(now time.Time) bool {
+	return !ls.Started.IsZero() && now.Before(ls.Started.Add(LightScanDuration))
+}
+
+// LastScan is the lastscan value of /lights/new, none before the first search.
+func (ls *LightScan) LastScan(now time.Time) string {
+	switch {
+	case ls.Started.IsZero():
+		return "none"
+	case ls.Active(now):
+		return "active"
+	}
+	return ls.Started.UTC().Format(HueTime)
+}
+
+func lightScanUpdate(tx *bolt.Tx, groupID string, fn func(ls *LightScan) error) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(groupID + "_lightScan"))
+	if err != nil {
+		return err
+	}
+	ls := &LightScan{}
+	if lsBytes := b.Get([]byte("scan")); lsBytes != nil {
+		if err = json.Unmarshal(lsBytes, ls); err != nil {
+			return err
+		}
+	}
+	if err = fn(ls); err != nil {
+		return err
+	}
+	if lsBytes, err := json.Marshal(ls); err != nil {
+		return err
+	} else {
+		return b.Put([]byte("scan"), lsBytes)
+	}
+}
+
+// recordNewLight lists an added light as found by the running search, or by the next one.
+func recordNewLight(tx *bolt.Tx, groupID string, lightID string) error {
+	return lightScanUpdate(tx, groupID, func(ls *LightScan) error {
+		if ls.Active(time.Now()) {
+			ls.Lights = append(ls.Lights, lightID)
+		} else {
+			ls.Pending = append(ls.Pending, lightID)
+		}
+		return nil
+	})
+}
+
+// StartLightScan starts a search for new lights, the lights added since the previous search become the new lights.
+// A search that is already running is left alone.
+func (d *DeviceDB) StartLightScan(groupID string) error {
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		return lightScanUpdate(tx, groupID, func(ls *LightScan) error {
+			now := time.Now()
+			if ls.Active(now) {
+				return nil
+			}
+			ls.Started = now
+			ls.Lights = ls.Pending
+			ls.Pending = nil
+			return nil
+		})
+	})
+}
+
+func (d *DeviceDB) GetLightScan(groupID string) (ls *LightScan, err error) {
+	err = d.DB.Update(func(tx *bolt.Tx) error {
+		return lightScanUpdate(tx, groupID, func(scan *LightScan) error {
+			ls = scan
+			return nil
+		})
+	})
+	return
+}
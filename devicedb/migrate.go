@@ -0,0 +1,158 @@
+package devicedb
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// legacyLightID reports if lightID is the sha256 of the light name used as the key by earlier releases.
+func legacyLightID(lightID string) bool {
+	if len(lightID) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(lightID)
+	return err == nil
+}
+
+// MigrateVirtualLights moves lights stored under sha256 name keys to allocated small integer ids,
+// rewriting the light ids referenced by the hue groups, scenes, schedules and rules of the group.
+func (d *DeviceDB) MigrateVirtualLights(groupID string) (migrated map[string]string, err error) {
+	migrated = make(map[string]string)
+
+	err = d.DB.Update(func(tx *bolt.Tx) error {
+		vlBucket, err := tx.CreateBucketIfNotExists([]byte(groupID + "_virtualLights"))
+		if err != nil {
+			return err
+		}
+
+		legacy := make(map[string][]byte)
+		names := make(map[string]string)
+		var oldIDs []string
+		c := vlBucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if legacyLightID(string(k)) {
+				legacy[string(k)] = append([]byte(nil), v...)
+				vl := &VirtualLight{}
+				if err := json.Unmarshal(v, vl); err == nil {
+					names[string(k)] = vl.Name
+				}
+				oldIDs = append(oldIDs, string(k))
+			}
+		}
+		if len(legacy) == 0 {
+			return nil
+		}
+
+		// ids are allocated in light name order so a migration always numbers the lights the same way
+		sort.Slice(oldIDs, func(i, j int) bool {
+			if names[oldIDs[i]] != names[oldIDs[j]] {
+				return names[oldIDs[i]] < names[oldIDs[j]]
+			}
+			return oldIDs[i] < oldIDs[j]
+		})
+
+		for _, oldID := range oldIDs {
+			vlBytes := legacy[oldID]
+			seq, err := vlBucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			newID := strconv.FormatUint(seq, 10)
+			if err = vlBucket.Put([]byte(newID), vlBytes); err != nil {
+				return err
+			}
+			if err = vlBucket.Delete([]byte(oldID)); err != nil {
+				return err
+			}
+			migrated[oldID] = newID
+		}
+
+		if err = rewriteBucket(tx, groupID+"_hueGroups", func(v []byte) ([]byte, error) {
+			hg := &HueGroup{}
+			if err := json.Unmarshal(v, hg); err != nil {
+				return v, nil
+			}
+			hg.Lights = migrateLightIDs(hg.Lights, migrated)
+			return json.Marshal(hg)
+		}); err != nil {
+			return err
+		}
+
+		if err = rewriteBucket(tx, groupID+"_scenes", func(v []byte) ([]byte, error) {
+			scene := &Scene{}
+			if err := json.Unmarshal(v, scene); err != nil {
+				return v, nil
+			}
+			scene.Lights = migrateLightIDs(scene.Lights, migrated)
+			lightStates := make(map[string]VirtualLightState)
+			for lightID, st := range scene.LightStates {
+				if newID, ok := migrated[lightID]; ok {
+					lightID = newID
+				}
+				lightStates[lightID] = st
+			}
+			scene.LightStates = lightStates
+			return json.Marshal(scene)
+		}); err != nil {
+			return err
+		}
+
+		// schedule commands and rule conditions and actions refer to lights by address
+		if err = rewriteBucket(tx, groupID+"_schedules", func(v []byte) ([]byte, error) {
+			schedule := &Schedule{}
+			if err := json.Unmarshal(v, schedule); err != nil {
+				return v, nil
+			}
+			if schedule.Command != nil {
+				schedule.Command.Address = migrateAddress(schedule.Command.Address, migrated)
+			}
+			return json.Marshal(schedule)
+		}); err != nil {
+			return err
+		}
+
+		return rewriteBucket(tx, groupID+"_rules", func(v []byte) ([]byte, error) {
+			rule := &Rule{}
+			if err := json.Unmarshal(v, rule); err != nil {
+				return v, nil
+			}
+			for _, condition := range rule.Conditions {
+				if condition != nil {
+					condition.Address = migrateAddress(condition.Address, migrated)
+				}
+			}
+			for _, action := range rule.Actions {
+				if action != nil {
+					action.Address = migrateAddress(action.Address, migrated)
+				}
+			}
+			return json.Marshal(rule)
+		})
+	})
+	return
+}
+
+// migrateAddress replaces a migrated light id in a hue api address.
+func migrateAddress(address string, migrated map[string]string) string {
+	if prefix, lightID, rest, ok := lightAddress(address); ok {
+		if newID, ok := migrated[lightID]; ok {
+			return prefix + newID + rest
+		}
+	}
+	return address
+}
+
+func migrateLightIDs(lightIDs []string, migrated map[string]string) []string {
+	result := make([]string, 0, len(lightIDs))
+	for _, lightID := range lightIDs {
+		if newID, ok := migrated[lightID]; ok {
+			lightID = newID
+		}
+		result = append(result, lightID)
+	}
+	return result
+}
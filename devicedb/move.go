@@ -0,0 +1,77 @@
+package devicedb
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// MoveVirtualLight moves a light, its options and delivery log to another device group within a single
+// transaction. The light gets a new id in the target group and is removed from the hue groups and scenes of the
+// source, whose schedules and rules referring to the light are deleted or marked resourcedeleted.
+func (d *DeviceDB) MoveVirtualLight(groupID string, lightID string, toGroupID string) (newLightID string, err error) {
+	if groupID == toGroupID {
+		return "", fmt.Errorf("virtual light %s already is in group %s", lightID, toGroupID)
+	}
+	err = d.DB.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("deviceGroups")) == nil || tx.Bucket([]byte("deviceGroups")).Get([]byte(toGroupID)) == nil {
+			return fmt.Errorf("device group %s does not exist", toGroupID)
+		}
+		from, err := tx.CreateBucketIfNotExists([]byte(groupID + "_virtualLights"))
+		if err != nil {
+			return err
+		}
+		vlBytes := from.Get([]byte(lightID))
+		if vlBytes == nil {
+			return fmt.Errorf("virtual light %s does not exist in group %s", lightID, groupID)
+		}
+		to, err := tx.CreateBucketIfNotExists([]byte(toGroupID + "_virtualLights"))
+		if err != nil {
+			return err
+		}
+		seq, err := to.NextSequence()
+		if err != nil {
+			return err
+		}
+		newLightID = strconv.FormatUint(seq, 10)
+		if err = to.Put([]byte(newLightID), vlBytes); err != nil {
+			return err
+		}
+		if err = from.Delete([]byte(lightID)); err != nil {
+			return err
+		}
+
+		for _, suffix := range []string{"_lightOptions", "_deliveries"} {
+			if err = moveValue(tx, groupID+suffix, lightID, toGroupID+suffix, newLightID); err != nil {
+				return err
+			}
+		}
+
+		if err = removeLightReferences(tx, groupID, lightID); err != nil {
+			return err
+		}
+		return recordNewLight(tx, toGroupID, newLightID)
+	})
+	return
+}
+
+// moveValue moves the value of key in bucket to toKey in toBucket, nothing is done when there is no value.
+func moveValue(tx *bolt.Tx, bucket string, key string, toBucket string, toKey string) error {
+	b := tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	v := b.Get([]byte(key))
+	if v == nil {
+		return nil
+	}
+	to, err := tx.CreateBucketIfNotExists([]byte(toBucket))
+	if err != nil {
+		return err
+	}
+	if err = to.Put([]byte(toKey), append([]byte{}, v...)); err != nil {
+		return err
+	}
+	return b.Delete([]byte(key))
+}
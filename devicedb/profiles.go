@@ -0,0 +1,100 @@
+package devicedb
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Hue light types, each has a profile of the state attributes it supports.
+const (
+	OnOffLight            = "On/Off plug-in unit"
+	DimmableLight         = "Dimmable light"
+	ColorTemperatureLight = "Color temperature light"
+	ExtendedColorLight    = "Extended color light"
+)
+
+// LightProfile describes the model and the state attributes of a light type.
+type LightProfile struct {
+	Type             string
+	ModelID          string
+	Dimmable         bool
+	ColorTemperature bool
+	Color            bool
+}
+
+var lightProfiles = map[string]*LightProfile{
+	OnOffLight:            {Type: OnOffLight, ModelID: "LOM001"},
+	DimmableLight:         {Type: DimmableLight, ModelID: "LWB010", Dimmable: true},
+	ColorTemperatureLight: {Type: ColorTemperatureLight, ModelID: "LTW001", Dimmable: true, ColorTemperature: true},
+	ExtendedColorLight:    {Type: ExtendedColorLight, ModelID: "LCT001", Dimmable: true, ColorTemperature: true, Color: true},
+}
+
+// GetLightProfile returns the profile of the light type, nil when the type is unknown.
+func GetLightProfile(lightType string) *LightProfile {
+	return lightProfiles[lightType]
+}
+
+// Profile returns the profile of the light, lights of an unknown type are extended color lights.
+func (vl *VirtualLight) Profile() *LightProfile {
+	if p := GetLightProfile(vl.Type); p != nil {
+		return p
+	}
+	return lightProfiles[ExtendedColorLight]
+}
+
+// Supports reports if the state attribute, or the attribute an increment applies to, exists for the profile.
+func (p *LightProfile) Supports(attribute string) bool {
+	switch strings.TrimSuffix(attribute, "_inc") {
+	case "bri":
+		return p.Dimmable
+	case "ct":
+		return p.ColorTemperature
+	case "colormode":
+		return p.ColorTemperature || p.Color
+	case "hue", "sat", "xy", "effect":
+		return p.Color
+	}
+	return true
+}
+
+// Filter returns a copy of sr without the attributes the profile does not support.
+func (p *LightProfile) Filter(sr *StateRequest) *StateRequest {
+	f := *sr
+	if !p.Dimmable {
+		f.Bri, f.BriInc = nil, nil
+	}
+	if !p.ColorTemperature {
+		f.Ct, f.CtInc = nil, nil
+	}
+	if !p.Color {
+		f.Hue, f.HueInc, f.Sat, f.SatInc, f.Xy, f.XyInc, f.Effect = nil, nil, nil, nil, nil, nil, nil
+	}
+	return &f
+}
+
+// MarshalJSON leaves out the state attributes the light type does not have.
+func (vl VirtualLight) MarshalJSON() ([]byte, error) {
+	type virtualLight VirtualLight
+	p := vl.Profile()
+	if p.Dimmable && p.ColorTemperature && p.Color {
+		return json.Marshal(virtualLight(vl))
+	}
+
+	stateBytes, err := json.Marshal(vl.State)
+	if err != nil {
+		return nil, err
+	}
+	state := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(stateBytes, &state); err != nil {
+		return nil, err
+	}
+	for attribute := range state {
+		if !p.Supports(attribute) {
+			delete(state, attribute)
+		}
+	}
+	return json.Marshal(struct {
+		virtualLight
+		State map[string]json.RawMessage `json:"state"`
+	}{virtualLight(vl), state})
+}
@@ -0,0 +1,135 @@
+package devicedb
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// lightAddress splits a hue api address such as /api/<user>/lights/3/state or /lights/3/state/on
+// around the light id it refers to, ok is false for addresses of other resources.
+func lightAddress(address string) (prefix string, lightID string, rest string, ok bool) {
+	i := strings.Index(address, "/lights/")
+	if i < 0 {
+		return "", "", "", false
+	}
+	prefix = address[:i+len("/lights/")]
+	lightID = address[len(prefix):]
+	if j := strings.Index(lightID, "/"); j >= 0 {
+		lightID, rest = lightID[:j], lightID[j:]
+	}
+	return prefix, lightID, rest, lightID != ""
+}
+
+// addressesLight reports if address refers to lightID.
+func addressesLight(address string, lightID string) bool {
+	_, id, _, ok := lightAddress(address)
+	return ok && id == lightID
+}
+
+// ruleAddressesLight reports if a condition or an action of the rule refers to lightID.
+func ruleAddressesLight(rule *Rule, lightID string) bool {
+	for _, condition := range rule.Conditions {
+		if condition != nil && addressesLight(condition.Address, lightID) {
+			return true
+		}
+	}
+	for _, action := range rule.Actions {
+		if action != nil && addressesLight(action.Address, lightID) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeLightReferences drops lightID from the hue groups and scenes of the device group, deletes the
+// schedules commanding the light and sets the status of the rules referring to it to resourcedeleted,
+// as a hue bridge does, so the rule engine no longer fires them.
+func removeLightReferences(tx *bolt.Tx, groupID string, lightID string) error {
+	hgBucket, err := tx.CreateBucketIfNotExists([]byte(groupID + "_hueGroups"))
+	if err != nil {
+		return err
+	}
+	if err = removeLightFromHueGroups(hgBucket, lightID); err != nil {
+		return err
+	}
+
+	if err = rewriteBucket(tx, groupID+"_scenes", func(v []byte) ([]byte, error) {
+		scene := &Scene{}
+		if err := json.Unmarshal(v, scene); err != nil {
+			return v, nil
+		}
+		lights := make([]string, 0, len(scene.Lights))
+		for _, id := range scene.Lights {
+			if id != lightID {
+				lights = append(lights, id)
+			}
+		}
+		_, stored := scene.LightStates[lightID]
+		if len(lights) == len(scene.Lights) && !stored {
+			return v, nil
+		}
+		scene.Lights = lights
+		delete(scene.LightStates, lightID)
+		return json.Marshal(scene)
+	}); err != nil {
+		return err
+	}
+
+	if err = rewriteBucket(tx, groupID+"_schedules", func(v []byte) ([]byte, error) {
+		schedule := &Schedule{}
+		if err := json.Unmarshal(v, schedule); err != nil {
+			return v, nil
+		}
+		if schedule.Command != nil && addressesLight(schedule.Command.Address, lightID) {
+			return nil, nil
+		}
+		return v, nil
+	}); err != nil {
+		return err
+	}
+
+	return rewriteBucket(tx, groupID+"_rules", func(v []byte) ([]byte, error) {
+		rule := &Rule{}
+		if err := json.Unmarshal(v, rule); err != nil {
+			return v, nil
+		}
+		if rule.Status == "resourcedeleted" || !ruleAddressesLight(rule, lightID) {
+			return v, nil
+		}
+		rule.Status = "resourcedeleted"
+		return json.Marshal(rule)
+	})
+}
+
+// rewriteBucket rewrites each value of the named bucket with fn, a nil value from fn deletes the key.
+// The bucket is skipped when it does not exist.
+func rewriteBucket(tx *bolt.Tx, name string, fn func(v []byte) ([]byte, error)) error {
+	b := tx.Bucket([]byte(name))
+	if b == nil {
+		return nil
+	}
+	updates := make(map[string][]byte)
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		updated, err := fn(append([]byte(nil), v...))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(updated, v) || updated == nil {
+			updates[string(k)] = updated
+		}
+	}
+	for k, v := range updates {
+		if v == nil {
+			if err := b.Delete([]byte(k)); err != nil {
+				return err
+			}
+		} else if err := b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package devicedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+type RuleCondition struct {
+	Address  string `json:"address"`
+	Operator string `json:"operator"`
+	Value    string `json:"value,omitempty"`
+}
+
+// Rule fires its actions when all conditions hold after a change of one of the resources they refer to.
+type Rule struct {
+	Name           string           `json:"name"`
+	Owner          string           `json:"owner"`
+	Created        string           `json:"created"`
+	LastTriggered  string           `json:"lasttriggered"`
+	TimesTriggered int              `json:"timestriggered"`
+	Status         string           `json:"status"`
+	Recycle        bool             `json:"recycle"`
+	Conditions     []*RuleCondition `json:"conditions"`
+	Actions        []*Command       `json:"actions"`
+}
+
+func (d *DeviceDB) rulesUpdate(groupID string, fn func(ruBucket *bolt.Bucket) error) error {
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(groupID + "_rules"))
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+func (d *DeviceDB) GetRules(groupID string) (rules map[string]*Rule, err error) {
+	rules = make(map[string]*Rule)
+
+	err = d.rulesUpdate(groupID, func(ruBucket *bolt.Bucket) error {
+		c := ruBucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			rule := &Rule{}
+			if err = json.Unmarshal(v, rule); err == nil {
+				rules[string(k)] = rule
+			} else {
+				if err := ruBucket.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (d *DeviceDB) GetRule(groupID string, ruleID string) (rule *Rule, err error) {
+	err = d.rulesUpdate(groupID, func(ruBucket *bolt.Bucket) error {
+		ruleBytes := ruBucket.Get([]byte(ruleID))
+		if ruleBytes == nil {
+			return fmt.Errorf("rule %s does not exist in group %s", ruleID, groupID)
+		} else {
+			rule = &Rule{}
+			return json.Unmarshal(ruleBytes, rule)
+		}
+	})
+	return
+}
+
+// AddRule stores a new rule and returns the id allocated for it.
+func (d *DeviceDB) AddRule(groupID string, rule *Rule) (ruleID string, err error) {
+	err = d.rulesUpdate(groupID, func(ruBucket *bolt.Bucket) error {
+		seq, err := ruBucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		ruleID = strconv.FormatUint(seq, 10)
+		if ruleBytes, err := json.Marshal(rule); err != nil {
+			return err
+		} else {
+			return ruBucket.Put([]byte(ruleID), ruleBytes)
+		}
+	})
+	return
+}
+
+func (d *DeviceDB) UpdateRule(groupID string, ruleID string, rule *Rule) error {
+	return d.rulesUpdate(groupID, func(ruBucket *bolt.Bucket) error {
+		if ruBucket.Get([]byte(ruleID)) == nil {
+			return fmt.Errorf("rule %s does not exist in group %s", ruleID, groupID)
+		}
+		if ruleBytes, err := json.Marshal(rule); err != nil {
+			return err
+		} else {
+			return ruBucket.Put([]byte(ruleID), ruleBytes)
+		}
+	})
+}
+
+func (d *DeviceDB) DeleteRule(groupID string, ruleID string) error {
+	return d.rulesUpdate(groupID, func(ruBucket *bolt.Bucket) error {
+		key := []byte(ruleID)
+		if ruBucket.Get(key) == nil {
+			return fmt.Errorf("rule %s does not exist in group %s", ruleID, groupID)
+		} else {
+			return ruBucket.Delete(key)
+		}
+	})
+}
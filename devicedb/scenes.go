@@ -0,0 +1,130 @@
+package devicedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Scene is a captured set of light states within a device group that can be recalled through a group action.
+type Scene struct {
+	Name        string                       `json:"name"`
+	Type        string                       `json:"type"`
+	Lights      []string                     `json:"lights"`
+	Owner       string                       `json:"owner"`
+	Recycle     bool                         `json:"recycle"`
+	Locked      bool                         `json:"locked"`
+	Picture     string                       `json:"picture"`
+	LastUpdated string                       `json:"lastupdated"`
+	Version     int                          `json:"version"`
+	LightStates map[string]VirtualLightState `json:"lightstates,omitempty"`
+}
+
+func NewScene(name string, owner string, lights []string) *Scene {
+	return &Scene{
+		Name:        name,
+		Type:        "LightScene",
+		Lights:      lights,
+		Owner:       owner,
+		Version:     2,
+		LightStates: make(map[string]VirtualLightState),
+	}
+}
+
+// Capture stores the current state of the scene lights found in lights.
+func (s *Scene) Capture(lights map[string]*VirtualLight) {
+	s.LightStates = make(map[string]VirtualLightState)
+	for _, lightID := range s.Lights {
+		if vl, ok := lights[lightID]; ok {
+			s.LightStates[lightID] = vl.State
+		}
+	}
+	s.LastUpdated = time.Now().UTC().Format(HueTime)
+}
+
+func (d *DeviceDB) scenesUpdate(groupID string, fn func(scBucket *bolt.Bucket) error) error {
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(groupID + "_scenes"))
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+func (d *DeviceDB) GetScenes(groupID string) (scenes map[string]*Scene, err error) {
+	scenes = make(map[string]*Scene)
+
+	err = d.scenesUpdate(groupID, func(scBucket *bolt.Bucket) error {
+		c := scBucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			scene := &Scene{}
+			if err = json.Unmarshal(v, scene); err == nil {
+				scenes[string(k)] = scene
+			} else {
+				if err := scBucket.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (d *DeviceDB) GetScene(groupID string, sceneID string) (scene *Scene, err error) {
+	err = d.scenesUpdate(groupID, func(scBucket *bolt.Bucket) error {
+		sceneBytes := scBucket.Get([]byte(sceneID))
+		if sceneBytes == nil {
+			return fmt.Errorf("scene %s does not exist in group %s", sceneID, groupID)
+		} else {
+			scene = &Scene{}
+			return json.Unmarshal(sceneBytes, scene)
+		}
+	})
+	return
+}
+
+// AddScene stores a new scene and returns the id allocated for it.
+func (d *DeviceDB) AddScene(groupID string, scene *Scene) (sceneID string, err error) {
+	err = d.scenesUpdate(groupID, func(scBucket *bolt.Bucket) error {
+		seq, err := scBucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		sceneID = strconv.FormatUint(seq, 10)
+		if sceneBytes, err := json.Marshal(scene); err != nil {
+			return err
+		} else {
+			return scBucket.Put([]byte(sceneID), sceneBytes)
+		}
+	})
+	return
+}
+
+func (d *DeviceDB) UpdateScene(groupID string, sceneID string, scene *Scene) error {
+	return d.scenesUpdate(groupID, func(scBucket *bolt.Bucket) error {
+		if scBucket.Get([]byte(sceneID)) == nil {
+			return fmt.Errorf("scene %s does not exist in group %s", sceneID, groupID)
+		}
+		if sceneBytes, err := json.Marshal(scene); err != nil {
+			return err
+		} else {
+			return scBucket.Put([]byte(sceneID), sceneBytes)
+		}
+	})
+}
+
+func (d *DeviceDB) DeleteScene(groupID string, sceneID string) error {
+	return d.scenesUpdate(groupID, func(scBucket *bolt.Bucket) error {
+		key := []byte(sceneID)
+		if scBucket.Get(key) == nil {
+			return fmt.Errorf("scene %s does not exist in group %s", sceneID, groupID)
+		} else {
+			return scBucket.Delete(key)
+		}
+	})
+}
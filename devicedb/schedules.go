@@ -0,0 +1,121 @@
+package devicedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// Command is a hue api request fired by a schedule or a rule action.
+type Command struct {
+	Address string          `json:"address"`
+	Method  string          `json:"method"`
+	Body    json.RawMessage `json:"body"`
+}
+
+type Schedule struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Command     *Command `json:"command"`
+	LocalTime   string   `json:"localtime"`
+	Status      string   `json:"status"`
+	AutoDelete  bool     `json:"autodelete"`
+	Created     string   `json:"created"`
+	StartTime   string   `json:"starttime,omitempty"`
+	Recycle     bool     `json:"recycle"`
+}
+
+func (d *DeviceDB) schedulesUpdate(groupID string, fn func(schBucket *bolt.Bucket) error) error {
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(groupID + "_schedules"))
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+func (d *DeviceDB) GetSchedules(groupID string) (schedules map[string]*Schedule, err error) {
+	schedules = make(map[string]*Schedule)
+
+	err = d.schedulesUpdate(groupID, func(schBucket *bolt.Bucket) error {
+		c := schBucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			schedule := &Schedule{}
+			if err = json.Unmarshal(v, schedule); err == nil {
+				schedules[string(k)] = schedule
+			} else {
+				if err := schBucket.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (d *DeviceDB) GetSchedule(groupID string, scheduleID string) (schedule *Schedule, err error) {
+	err = d.schedulesUpdate(groupID, func(schBucket *bolt.Bucket) error {
+		scheduleBytes := schBucket.Get([]byte(scheduleID))
+		if scheduleBytes == nil {
+			return fmt.Errorf("schedule %s does not exist in group %s", scheduleID, groupID)
+		} else {
+			schedule = &Schedule{}
+			return json.Unmarshal(scheduleBytes, schedule)
+		}
+	})
+	return
+}
+
+// AddSchedule stores a new schedule and returns the id allocated for it. The schedules are counted in the
+// same transaction, so the group never holds more than max schedules.
+func (d *DeviceDB) AddSchedule(groupID string, schedule *Schedule, max int) (scheduleID string, err error) {
+	err = d.schedulesUpdate(groupID, func(schBucket *bolt.Bucket) error {
+		count := 0
+		c := schBucket.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			count++
+		}
+		if count >= max {
+			return fmt.Errorf("schedule table of group %s is full", groupID)
+		}
+		seq, err := schBucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		scheduleID = strconv.FormatUint(seq, 10)
+		if scheduleBytes, err := json.Marshal(schedule); err != nil {
+			return err
+		} else {
+			return schBucket.Put([]byte(scheduleID), scheduleBytes)
+		}
+	})
+	return
+}
+
+func (d *DeviceDB) UpdateSchedule(groupID string, scheduleID string, schedule *Schedule) error {
+	return d.schedulesUpdate(groupID, func(schBucket *bolt.Bucket) error {
+		if schBucket.Get([]byte(scheduleID)) == nil {
+			return fmt.Errorf("schedule %s does not exist in group %s", scheduleID, groupID)
+		}
+		if scheduleBytes, err := json.Marshal(schedule); err != nil {
+			return err
+		} else {
+			return schBucket.Put([]byte(scheduleID), scheduleBytes)
+		}
+	})
+}
+
+func (d *DeviceDB) DeleteSchedule(groupID string, scheduleID string) error {
+	return d.schedulesUpdate(groupID, func(schBucket *bolt.Bucket) error {
+		key := []byte(scheduleID)
+		if schBucket.Get(key) == nil {
+			return fmt.Errorf("schedule %s does not exist in group %s", scheduleID, groupID)
+		} else {
+			return schBucket.Delete(key)
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package devicedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Virtual sensor types that can be created through the hue api.
+const (
+	SensorGenericFlag   = "CLIPGenericFlag"
+	SensorGenericStatus = "CLIPGenericStatus"
+	SensorDaylight      = "Daylight"
+)
+
+type SensorState struct {
+	Flag        *bool  `json:"flag,omitempty"`
+	Status      *int   `json:"status,omitempty"`
+	Daylight    *bool  `json:"daylight,omitempty"`
+	LastUpdated string `json:"lastupdated"`
+}
+
+type SensorConfig struct {
+	On            bool    `json:"on"`
+	Reachable     bool    `json:"reachable"`
+	Configured    *bool   `json:"configured,omitempty"`
+	Lat           *string `json:"lat,omitempty"`
+	Long          *string `json:"long,omitempty"`
+	SunriseOffset *int    `json:"sunriseoffset,omitempty"`
+	SunsetOffset  *int    `json:"sunsetoffset,omitempty"`
+}
+
+type Sensor struct {
+	State            SensorState  `json:"state"`
+	Config           SensorConfig `json:"config"`
+	Name             string       `json:"name"`
+	Type             string       `json:"type"`
+	ModelID          string       `json:"modelid"`
+	ManufacturerName string       `json:"manufacturername"`
+	SwVersion        string       `json:"swversion"`
+	UniqueID         string       `json:"uniqueid"`
+	Recycle          bool         `json:"recycle"`
+}
+
+// NewSensor creates a virtual sensor of one of the supported types, returning nil for any other type.
+func NewSensor(name string, sensorType string) *Sensor {
+	s := &Sensor{
+		Name:             name,
+		Type:             sensorType,
+		ManufacturerName: "vhugo",
+		SwVersion:        "1.0",
+		Config:           SensorConfig{On: true, Reachable: true},
+	}
+	switch sensorType {
+	case SensorGenericFlag:
+		flag := false
+		s.State.Flag = &flag
+		s.ModelID = "GenericFlag"
+	case SensorGenericStatus:
+		status := 0
+		s.State.Status = &status
+		s.ModelID = "GenericStatus"
+	case SensorDaylight:
+		configured, offset := false, 30
+		s.ModelID = "PHDL00"
+		s.Config.Configured = &configured
+		s.Config.SunriseOffset = &offset
+		s.Config.SunsetOffset = &offset
+	default:
+		return nil
+	}
+	s.Touch()
+	return s
+}
+
+// Touch sets the last updated time of the sensor state to now.
+func (s *Sensor) Touch() {
+	s.State.LastUpdated = time.Now().UTC().Format(HueTime)
+}
+
+func (d *DeviceDB) sensorsUpdate(groupID string, fn func(snBucket *bolt.Bucket) error) error {
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(groupID + "_sensors"))
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+func (d *DeviceDB) GetSensors(groupID string) (sensors map[string]*Sensor, err error) {
+	sensors = make(map[string]*Sensor)
+
+	err = d.sensorsUpdate(groupID, func(snBucket *bolt.Bucket) error {
+		c := snBucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			sensor := &Sensor{}
+			if err = json.Unmarshal(v, sensor); err == nil {
+				sensors[string(k)] = sensor
+			} else {
+				if err := snBucket.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (d *DeviceDB) GetSensor(groupID string, sensorID string) (sensor *Sensor, err error) {
+	err = d.sensorsUpdate(groupID, func(snBucket *bolt.Bucket) error {
+		sensorBytes := snBucket.Get([]byte(sensorID))
+		if sensorBytes == nil {
+			return fmt.Errorf("sensor %s does not exist in group %s", sensorID, groupID)
+		} else {
+			sensor = &Sensor{}
+			return json.Unmarshal(sensorBytes, sensor)
+		}
+	})
+	return
+}
+
+// AddSensor stores a new sensor and returns the id allocated for it.
+func (d *DeviceDB) AddSensor(groupID string, sensor *Sensor) (sensorID string, err error) {
+	err = d.sensorsUpdate(groupID, func(snBucket *bolt.Bucket) error {
+		seq, err := snBucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		sensorID = strconv.FormatUint(seq, 10)
+		if sensor.UniqueID == "" {
+			sensor.UniqueID = fmt.Sprintf("%s-%s", groupID, sensorID)
+		}
+		if sensorBytes, err := json.Marshal(sensor); err != nil {
+			return err
+		} else {
+			return snBucket.Put([]byte(sensorID), sensorBytes)
+		}
+	})
+	return
+}
+
+func (d *DeviceDB) UpdateSensor(groupID string, sensorID string, sensor *Sensor) error {
+	return d.sensorsUpdate(groupID, func(snBucket *bolt.Bucket) error {
+		if snBucket.Get([]byte(sensorID)) == nil {
+			return fmt.Errorf("sensor %s does not exist in group %s", sensorID, groupID)
+		}
+		if sensorBytes, err := json.Marshal(sensor); err != nil {
+			return err
+		} else {
+			return snBucket.Put([]byte(sensorID), sensorBytes)
+		}
+	})
+}
+
+func (d *DeviceDB) DeleteSensor(groupID string, sensorID string) error {
+	return d.sensorsUpdate(groupID, func(snBucket *bolt.Bucket) error {
+		key := []byte(sensorID)
+		if snBucket.Get(key) == nil {
+			return fmt.Errorf("sensor %s does not exist in group %s", sensorID, groupID)
+		} else {
+			return snBucket.Delete(key)
+		}
+	})
+}
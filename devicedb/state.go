@@ -0,0 +1,226 @@
+package devicedb
+
+// Hue light state ranges, see https://developers.meethue.com/develop/hue-api/lights-api/
+const (
+	MinBri = 1
+	MaxBri = 254
+	MaxHue = 65535
+	MaxSat = 254
+	MinCt  = 153
+	MaxCt  = 500
+)
+
+// StateRequest holds the writable fields of a Hue light state. Nil fields are left unchanged.
+// TransitionTime does not change the stored state, it travels with the request in the change
+// event so the device can fade to the new state.
+type StateRequest struct {
+	On             *bool     `json:"on"`
+	Bri            *int32    `json:"bri"`
+	Hue            *int32    `json:"hue"`
+	Sat            *int32    `json:"sat"`
+	Xy             []float32 `json:"xy"`
+	Ct             *int32    `json:"ct"`
+	Alert          *string   `json:"alert"`
+	Effect         *string   `json:"effect"`
+	TransitionTime *uint16   `json:"transitiontime"`
+	BriInc         *int32    `json:"bri_inc"`
+	SatInc         *int32    `json:"sat_inc"`
+	HueInc         *int32    `json:"hue_inc"`
+	CtInc          *int32    `json:"ct_inc"`
+	XyInc          []float32 `json:"xy_inc"`
+}
+
+// LightStateReport is published on the lightStateReport subject by a device whose state changed on its own.
+// Once the report is stored the state is published on lightStateChange with the device source.
+type LightStateReport struct {
+	GroupID      string        `json:"groupID"`
+	LightID      string        `json:"lightID"`
+	StateRequest *StateRequest `json:"stateRequest"`
+}
+
+// UpdateState applies the non nil fields of the request to the light state, clamping values
+// to the Hue ranges. The color mode follows the color fields in the order hs, ct, xy so that
+// xy wins over ct and ct over hue/sat when more than one is present, as on a real bridge.
+// Attributes the light type does not support are ignored.
+func (vl *VirtualLight) UpdateState(sr *StateRequest) {
+	st := &vl.State
+	sr = vl.Profile().Filter(sr)
+
+	if sr.On != nil {
+		st.On = *sr.On
+	}
+	if sr.Bri != nil {
+		st.Bri = clamp(*sr.Bri, MinBri, MaxBri)
+	}
+	if sr.BriInc != nil {
+		st.Bri = clamp(st.Bri+clamp(*sr.BriInc, -MaxBri, MaxBri), MinBri, MaxBri)
+	}
+
+	if sr.Hue != nil {
+		st.Hue = clamp(*sr.Hue, 0, MaxHue)
+		st.Colormode = "hs"
+	}
+	if sr.HueInc != nil {
+		// hue is a color wheel and wraps around instead of clamping
+		st.Hue = (st.Hue + clamp(*sr.HueInc, -(MaxHue-1), MaxHue-1)) % (MaxHue + 1)
+		if st.Hue < 0 {
+			st.Hue += MaxHue + 1
+		}
+		st.Colormode = "hs"
+	}
+	if sr.Sat != nil {
+		st.Sat = clamp(*sr.Sat, 0, MaxSat)
+		st.Colormode = "hs"
+	}
+	if sr.SatInc != nil {
+		st.Sat = clamp(st.Sat+clamp(*sr.SatInc, -MaxSat, MaxSat), 0, MaxSat)
+		st.Colormode = "hs"
+	}
+
+	if sr.Ct != nil {
+		st.Ct = clamp(*sr.Ct, MinCt, MaxCt)
+		st.Colormode = "ct"
+	}
+	if sr.CtInc != nil {
+		st.Ct = clamp(st.Ct+clamp(*sr.CtInc, -(MaxCt-MinCt), MaxCt-MinCt), MinCt, MaxCt)
+		st.Colormode = "ct"
+	}
+
+	if len(sr.Xy) == 2 {
+		st.Xy = []float32{clampXy(sr.Xy[0]), clampXy(sr.Xy[1])}
+		st.Colormode = "xy"
+	}
+	if len(sr.XyInc) == 2 && len(st.Xy) == 2 {
+		st.Xy = []float32{
+			clampXy(st.Xy[0] + clampXyInc(sr.XyInc[0])),
+			clampXy(st.Xy[1] + clampXyInc(sr.XyInc[1])),
+		}
+		st.Colormode = "xy"
+	}
+
+	if sr.Alert != nil && validAlert(*sr.Alert) {
+		st.Alert = *sr.Alert
+	}
+	if sr.Effect != nil && validEffect(*sr.Effect) {
+		st.Effect = *sr.Effect
+	}
+}
+
+func clamp(v, min, max int32) int32 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func clampXy(v float32) float32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+func clampXyInc(v float32) float32 {
+	if v < -0.5 {
+		return -0.5
+	}
+	if v > 0.5 {
+		return 0.5
+	}
+	return v
+}
+
+// StateField is a state attribute touched by a StateRequest and its resulting value.
+type StateField struct {
+	Name  string
+	Value interface{}
+}
+
+// Applied lists the attributes set by the request with their values taken from st, which is
+// expected to be the state after UpdateState. Increments are reported under their own name.
+func (sr *StateRequest) Applied(st VirtualLightState) (fields []StateField) {
+	add := func(set bool, name string, value interface{}) {
+		if set {
+			fields = append(fields, StateField{Name: name, Value: value})
+		}
+	}
+	add(sr.On != nil, "on", st.On)
+	add(sr.Bri != nil, "bri", st.Bri)
+	add(sr.BriInc != nil, "bri_inc", st.Bri)
+	add(sr.Hue != nil, "hue", st.Hue)
+	add(sr.HueInc != nil, "hue_inc", st.Hue)
+	add(sr.Sat != nil, "sat", st.Sat)
+	add(sr.SatInc != nil, "sat_inc", st.Sat)
+	add(sr.Ct != nil, "ct", st.Ct)
+	add(sr.CtInc != nil, "ct_inc", st.Ct)
+	add(len(sr.Xy) == 2, "xy", st.Xy)
+	add(len(sr.XyInc) == 2, "xy_inc", st.Xy)
+	add(sr.Alert != nil, "alert", st.Alert)
+	add(sr.Effect != nil, "effect", st.Effect)
+	if sr.TransitionTime != nil {
+		add(true, "transitiontime", *sr.TransitionTime)
+	}
+	return
+}
+
+// Invalid removes the alert and effect values the hue api does not define and the xy and xy_inc
+// values that are not a pair from the request and returns them, so they are reported as invalid
+// values instead of being ignored.
+func (sr *StateRequest) Invalid() (fields []StateField) {
+	if sr.Alert != nil && !validAlert(*sr.Alert) {
+		fields = append(fields, StateField{Name: "alert", Value: *sr.Alert})
+		sr.Alert = nil
+	}
+	if sr.Effect != nil && !validEffect(*sr.Effect) {
+		fields = append(fields, StateField{Name: "effect", Value: *sr.Effect})
+		sr.Effect = nil
+	}
+	if sr.Xy != nil && len(sr.Xy) != 2 {
+		fields = append(fields, StateField{Name: "xy", Value: sr.Xy})
+		sr.Xy = nil
+	}
+	if sr.XyInc != nil && len(sr.XyInc) != 2 {
+		fields = append(fields, StateField{Name: "xy_inc", Value: sr.XyInc})
+		sr.XyInc = nil
+	}
+	return
+}
+
+func validAlert(alert string) bool {
+	return alert == "none" || alert == "select" || alert == "lselect"
+}
+
+func validEffect(effect string) bool {
+	return effect == "none" || effect == "colorloop"
+}
+
+// Empty is true when the request does not set any attribute.
+func (sr *StateRequest) Empty() bool {
+	return len(sr.Applied(VirtualLightState{})) == 0
+}
+
+// StateRequestFor builds the request that restores st on a light, using the color fields of its color mode.
+func StateRequestFor(st VirtualLightState) *StateRequest {
+	on, bri, effect := st.On, st.Bri, st.Effect
+	sr := &StateRequest{On: &on, Effect: &effect}
+	if bri >= MinBri {
+		sr.Bri = &bri
+	}
+	switch st.Colormode {
+	case "xy":
+		sr.Xy = append([]float32{}, st.Xy...)
+	case "ct":
+		ct := st.Ct
+		sr.Ct = &ct
+	case "hs":
+		hue, sat := st.Hue, st.Sat
+		sr.Hue, sr.Sat = &hue, &sat
+	}
+	return sr
+}
@@ -0,0 +1,158 @@
+package devicedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"gopkg.in/satori/go.uuid.v1"
+)
+
+// HueTime is the timestamp layout used by the hue api.
+const HueTime = "2006-01-02T15:04:05"
+
+// LastUseInterval is how old the last use date of a whitelist user gets before a request updates it, so
+// that not every api request writes to the database.
+const LastUseInterval = time.Minute
+
+type WhitelistUser struct {
+	Username    string `json:"-"`
+	Name        string `json:"name"`
+	CreateDate  string `json:"create date"`
+	LastUseDate string `json:"last use date"`
+}
+
+func (d *DeviceDB) whitelistUpdate(fn func(wlBucket *bolt.Bucket) error) error {
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("whitelist"))
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+func (d *DeviceDB) configUpdate(fn func(cfgBucket *bolt.Bucket) error) error {
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("config"))
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+// AddWhitelistUser registers a new user for the provided hue devicetype and returns it with a generated username.
+func (d *DeviceDB) AddWhitelistUser(deviceType string) (user *WhitelistUser, err error) {
+	now := time.Now().UTC().Format(HueTime)
+	user = &WhitelistUser{
+		Username:    strings.Replace(uuid.NewV4().String(), "-", "", -1),
+		Name:        deviceType,
+		CreateDate:  now,
+		LastUseDate: now,
+	}
+	err = d.whitelistUpdate(func(wlBucket *bolt.Bucket) error {
+		if userBytes, err := json.Marshal(user); err != nil {
+			return err
+		} else {
+			return wlBucket.Put([]byte(user.Username), userBytes)
+		}
+	})
+	return
+}
+
+func (d *DeviceDB) GetWhitelist() (users map[string]*WhitelistUser, err error) {
+	users = make(map[string]*WhitelistUser)
+
+	err = d.whitelistUpdate(func(wlBucket *bolt.Bucket) error {
+		c := wlBucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			user := &WhitelistUser{}
+			if err = json.Unmarshal(v, user); err == nil {
+				user.Username = string(k)
+				users[string(k)] = user
+			} else {
+				if err := wlBucket.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (d *DeviceDB) GetWhitelistUser(username string) (user *WhitelistUser, err error) {
+	err = d.whitelistUpdate(func(wlBucket *bolt.Bucket) error {
+		userBytes := wlBucket.Get([]byte(username))
+		if userBytes == nil {
+			return fmt.Errorf("user %s does not exist", username)
+		} else {
+			user = &WhitelistUser{Username: username}
+			return json.Unmarshal(userBytes, user)
+		}
+	})
+	return
+}
+
+// LastUseStale is true when the last use date of the user is LastUseInterval or more before now.
+func (u *WhitelistUser) LastUseStale(now time.Time) bool {
+	lastUse, err := time.Parse(HueTime, u.LastUseDate)
+	return err != nil || now.Sub(lastUse) >= LastUseInterval
+}
+
+// UseWhitelistUser sets the last use date of the user to now.
+func (d *DeviceDB) UseWhitelistUser(username string, now time.Time) error {
+	return d.whitelistUpdate(func(wlBucket *bolt.Bucket) error {
+		userBytes := wlBucket.Get([]byte(username))
+		if userBytes == nil {
+			return fmt.Errorf("user %s does not exist", username)
+		}
+		user := &WhitelistUser{}
+		if err := json.Unmarshal(userBytes, user); err != nil {
+			return err
+		}
+		user.LastUseDate = now.UTC().Format(HueTime)
+		if userBytes, err := json.Marshal(user); err != nil {
+			return err
+		} else {
+			return wlBucket.Put([]byte(username), userBytes)
+		}
+	})
+}
+
+func (d *DeviceDB) DeleteWhitelistUser(username string) error {
+	return d.whitelistUpdate(func(wlBucket *bolt.Bucket) error {
+		if wlBucket.Get([]byte(username)) == nil {
+			return fmt.Errorf("user %s does not exist", username)
+		} else {
+			return wlBucket.Delete([]byte(username))
+		}
+	})
+}
+
+// PressLinkButton emulates pressing the link button on the bridge, allowing new users to register for duration.
+func (d *DeviceDB) PressLinkButton(duration time.Duration) error {
+	return d.configUpdate(func(cfgBucket *bolt.Bucket) error {
+		until := time.Now().Add(duration).Format(time.RFC3339)
+		return cfgBucket.Put([]byte("linkButtonUntil"), []byte(until))
+	})
+}
+
+func (d *DeviceDB) LinkButtonPressed() (pressed bool, err error) {
+	err = d.configUpdate(func(cfgBucket *bolt.Bucket) error {
+		untilBytes := cfgBucket.Get([]byte("linkButtonUntil"))
+		if untilBytes == nil {
+			return nil
+		}
+		until, err := time.Parse(time.RFC3339, string(untilBytes))
+		if err != nil {
+			return cfgBucket.Delete([]byte("linkButtonUntil"))
+		}
+		pressed = time.Now().Before(until)
+		return nil
+	})
+	return
+}
@@ -0,0 +1,278 @@
+// Package devicegroups starts and stops the hue api server of each device group, at startup and
+// when groups are created, deleted or resized from the web app.
+package devicegroups
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/mlctrez/vhugo/apiserver"
+	"github.com/mlctrez/vhugo/config"
+	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/vhugo/hlog"
+	"github.com/mlctrez/vhugo/lightstate"
+	"github.com/mlctrez/vhugo/natsserver"
+	"github.com/mlctrez/vhugo/network"
+	"github.com/mlctrez/vhugo/rules"
+	"github.com/mlctrez/vhugo/scheduler"
+)
+
+// Group is a device group with the number of lights it holds.
+type Group struct {
+	GroupID  string `json:"group_id"`
+	Name     string `json:"name"`
+	BridgeID string `json:"bridge_id"`
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Lights   int    `json:"lights"`
+	Running  bool   `json:"running"`
+}
+
+type running struct {
+	cancel func()
+	done   chan struct{}
+}
+
+type Manager struct {
+	DB        *devicedb.DeviceDB
+	NS        *natsserver.NatsServer
+	Lights    *lightstate.Service
+	Scheduler *scheduler.Scheduler
+	Engine    *rules.Engine
+	// Interfaces are the network interfaces the api servers answer discovery on.
+	Interfaces []*network.Interface
+	config     *config.Config
+	logger     *hlog.HLog
+	baseLog    *log.Logger
+	ctx        context.Context
+	lock       sync.Mutex
+	running    map[string]*running
+	// lastPlaced is the device group the previous light was added to, for round-robin placement.
+	lastPlaced string
+}
+
+func New(cfg *config.Config, db *devicedb.DeviceDB, ns *natsserver.NatsServer, lights *lightstate.Service,
+	sched *scheduler.Scheduler, engine *rules.Engine, logger *log.Logger) *Manager {
+	return &Manager{
+		DB:        db,
+		NS:        ns,
+		Lights:    lights,
+		Scheduler: sched,
+		Engine:    engine,
+		config:    cfg,
+		logger:    hlog.New(logger, "DeviceGroups"),
+		baseLog:   logger,
+		running:   make(map[string]*running),
+	}
+}
+
+// Start creates the initial device groups when there are none yet, migrates the lights of the
+// existing groups and starts their api servers. The servers stop when ctx is done.
+func (m *Manager) Start(ctx context.Context) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.ctx = ctx
+
+	deviceGroups, err := m.DB.GetDeviceGroups()
+	if err != nil {
+		return err
+	}
+	if len(deviceGroups) == 0 {
+		for i := 0; i < m.config.DeviceGroups; i++ {
+			if _, err = m.create(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, dg := range deviceGroups {
+		if dg.ServerIP != m.config.IP {
+			m.logger.Println("changing ip of", dg.GroupID, "from", dg.ServerIP, "to", m.config.IP)
+			dg.ServerIP = m.config.IP
+			if err = m.DB.UpdateDeviceGroup(dg); err != nil {
+				return err
+			}
+		}
+		migrated, err := m.DB.MigrateVirtualLights(dg.GroupID)
+		if err != nil {
+			return err
+		}
+		for oldID, newID := range migrated {
+			m.logger.Println("migrated light", dg.GroupID, oldID, "to", newID)
+		}
+		m.start(dg)
+	}
+	return nil
+}
+
+// Groups lists the device groups ordered by port.
+func (m *Manager) Groups() (groups []*Group, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.groups()
+}
+
+func (m *Manager) groups() (groups []*Group, err error) {
+	deviceGroups, err := m.DB.GetDeviceGroups()
+	if err != nil {
+		return nil, err
+	}
+	groups = make([]*Group, 0, len(deviceGroups))
+	for _, dg := range deviceGroups {
+		lights, err := m.DB.GetVirtualLights(dg.GroupID)
+		if err != nil {
+			return nil, err
+		}
+		isRunning := false
+		if r, ok := m.running[dg.GroupID]; ok {
+			select {
+			case <-r.done:
+			default:
+				isRunning = true
+			}
+		}
+		groups = append(groups, &Group{
+			GroupID:  dg.GroupID,
+			Name:     dg.Name(),
+			BridgeID: dg.BridgeID(),
+			IP:       dg.ServerIP,
+			Port:     dg.ServerPort,
+			Lights:   len(lights),
+			Running:  isRunning,
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Port < groups[j].Port })
+	return groups, nil
+}
+
+// Create adds a device group on the first free api port and starts its api server.
+func (m *Manager) Create() (dg *devicedb.DeviceGroup, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.create()
+}
+
+func (m *Manager) create() (dg *devicedb.DeviceGroup, err error) {
+	deviceGroups, err := m.DB.GetDeviceGroups()
+	if err != nil {
+		return nil, err
+	}
+	if len(deviceGroups) >= m.config.MaxDeviceGroups {
+		return nil, fmt.Errorf("at most %d device groups are allowed", m.config.MaxDeviceGroups)
+	}
+	used := make(map[int]bool)
+	for _, existing := range deviceGroups {
+		used[existing.ServerPort] = true
+	}
+	port := m.config.APIPort()
+	for used[port] {
+		port++
+	}
+
+	dg = devicedb.NewDeviceGroup(fmt.Sprintf("group%d", port))
+	dg.ServerIP = m.config.IP
+	dg.ServerPort = port
+	m.logger.Println("adding device group", dg)
+	if err = m.DB.AddDeviceGroup(dg); err != nil {
+		return nil, err
+	}
+	m.start(dg)
+	return dg, nil
+}
+
+// Delete stops the api server of an empty device group and removes the group.
+func (m *Manager) Delete(groupID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.delete(groupID)
+}
+
+func (m *Manager) delete(groupID string) error {
+	if _, err := m.DB.GetDeviceGroup(groupID); err != nil {
+		return err
+	}
+	lights, err := m.DB.GetVirtualLights(groupID)
+	if err != nil {
+		return err
+	}
+	if len(lights) > 0 {
+		return fmt.Errorf("device group %s is not empty, it has %d lights", groupID, len(lights))
+	}
+	deviceGroups, err := m.DB.GetDeviceGroups()
+	if err != nil {
+		return err
+	}
+	if len(deviceGroups) == 1 {
+		return fmt.Errorf("device group %s is the last device group", groupID)
+	}
+	m.stop(groupID)
+	m.logger.Println("deleting device group", groupID)
+	return m.DB.DeleteDeviceGroup(groupID)
+}
+
+// Resize creates or deletes device groups until there are count of them. Groups on the highest
+// ports are deleted first, nothing is deleted unless all of them are empty.
+func (m *Manager) Resize(count int) (groups []*Group, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if count < 1 || count > m.config.MaxDeviceGroups {
+		return nil, fmt.Errorf("invalid device group count %d, must be between 1 and %d", count, m.config.MaxDeviceGroups)
+	}
+	if groups, err = m.groups(); err != nil {
+		return nil, err
+	}
+	if count < len(groups) {
+		remove := groups[count:]
+		for _, g := range remove {
+			if g.Lights > 0 {
+				return nil, fmt.Errorf("device group %s is not empty, it has %d lights", g.GroupID, g.Lights)
+			}
+		}
+		for _, g := range remove {
+			if err = m.delete(g.GroupID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i := len(groups); i < count; i++ {
+		if _, err = m.create(); err != nil {
+			return nil, err
+		}
+	}
+	return m.groups()
+}
+
+// start runs the api server of a device group and registers it with the scheduler and rules engine.
+func (m *Manager) start(dg *devicedb.DeviceGroup) {
+	m.logger.Println("starting", dg)
+	api := apiserver.New(m.DB, dg, m.NS, m.Lights, m.baseLog)
+	api.SSDPAddress = m.config.SSDPAddress
+	api.Interfaces = m.Interfaces
+	m.Scheduler.Register(dg.GroupID, api)
+	m.Engine.Register(dg.GroupID, api)
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	r := &running{cancel: cancel, done: make(chan struct{})}
+	m.running[dg.GroupID] = r
+	go func() {
+		defer close(r.done)
+		api.Run(ctx)
+	}()
+}
+
+// stop shuts the api server of a device group down and waits until its port is released.
+func (m *Manager) stop(groupID string) {
+	m.Scheduler.Unregister(groupID)
+	m.Engine.Unregister(groupID)
+	if r, ok := m.running[groupID]; ok {
+		m.logger.Println("stopping", groupID)
+		r.cancel()
+		<-r.done
+		delete(m.running, groupID)
+	}
+}
@@ -0,0 +1,96 @@
+package devicegroups
+
+import (
+	"fmt"
+
+	"github.com/mlctrez/vhugo/devicedb"
+)
+
+// Placement strategies for new lights.
+const (
+	// FillFirst adds lights to the first device group, by port, that has room.
+	FillFirst = "fill-first"
+	// RoundRobin adds each light to the device group after the one the previous light went to.
+	RoundRobin = "round-robin"
+	// Pinned adds every light to the configured placement group.
+	Pinned = "pinned"
+)
+
+// AddLight stores a new light in the device group chosen by the placement strategy, or in groupID
+// when it is not empty. A device group is created when every group is full.
+func (m *Manager) AddLight(vl *devicedb.VirtualLight, groupID string) (placedGroupID string, lightID string, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if placedGroupID, err = m.place(groupID); err != nil {
+		return "", "", err
+	}
+	if lightID, err = m.Lights.Add(placedGroupID, vl); err != nil {
+		return "", "", err
+	}
+	m.lastPlaced = placedGroupID
+	return
+}
+
+// MoveLight moves a light to another device group that has room for it and returns its new light id.
+func (m *Manager) MoveLight(groupID string, lightID string, toGroupID string) (newLightID string, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, err = m.DB.GetVirtualLight(groupID, lightID); err != nil {
+		return "", err
+	}
+	if _, err = m.pinned(toGroupID); err != nil {
+		return "", err
+	}
+	return m.Lights.Move(groupID, lightID, toGroupID)
+}
+
+func (m *Manager) place(groupID string) (string, error) {
+	if groupID == "" && m.config.Placement == Pinned {
+		groupID = m.config.PlacementGroup
+	}
+	if groupID != "" {
+		return m.pinned(groupID)
+	}
+
+	groups, err := m.groups()
+	if err != nil {
+		return "", err
+	}
+	start := 0
+	if m.config.Placement == RoundRobin {
+		for i, g := range groups {
+			if g.GroupID == m.lastPlaced {
+				start = i + 1
+			}
+		}
+	}
+	for i := range groups {
+		g := groups[(start+i)%len(groups)]
+		if g.Lights < m.config.MaxLightsPerGroup {
+			return g.GroupID, nil
+		}
+	}
+
+	dg, err := m.create()
+	if err != nil {
+		return "", fmt.Errorf("all device groups are full, %v", err)
+	}
+	return dg.GroupID, nil
+}
+
+// pinned checks that the named device group has room for another light.
+func (m *Manager) pinned(groupID string) (string, error) {
+	if _, err := m.DB.GetDeviceGroup(groupID); err != nil {
+		return "", err
+	}
+	lights, err := m.DB.GetVirtualLights(groupID)
+	if err != nil {
+		return "", err
+	}
+	if len(lights) >= m.config.MaxLightsPerGroup {
+		return "", fmt.Errorf("device group %s is full, it has %d lights", groupID, len(lights))
+	}
+	return groupID, nil
+}
@@ -1,27 +1,31 @@
 module github.com/mlctrez/vhugo
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/boltdb/bolt v1.3.1
-	github.com/gorilla/websocket v1.4.1
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/gorilla/websocket v1.5.0
+	github.com/kardianos/service v1.2.1
+	github.com/mlctrez/servicego v1.3.0
 	github.com/mlctrez/web v1.1.0
 	github.com/mlctrez/zipbackpack v1.0.0
 	github.com/nats-io/gnatsd v1.1.0
 	github.com/nats-io/go-nats v1.5.0
+	golang.org/x/net v0.8.0
 	gopkg.in/satori/go.uuid.v1 v1.2.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/golang/protobuf v1.2.0 // indirect
-	github.com/kardianos/service v1.2.1 // indirect
 	github.com/kr/pretty v0.1.0 // indirect
-	github.com/mlctrez/servicego v1.3.0 // indirect
 	github.com/nats-io/nuid v1.0.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/testify v1.2.2 // indirect
 	golang.org/x/crypto v0.0.0-20180621125126-a49355c7e3f8 // indirect
-	golang.org/x/sync v0.0.0-20181221193216-37e7f081c4d4 // indirect
-	golang.org/x/sys v0.0.0-20201015000850-e3ed0017c211 // indirect
+	golang.org/x/sync v0.1.0 // indirect
+	golang.org/x/sys v0.6.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 )
 
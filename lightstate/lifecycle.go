@@ -0,0 +1,120 @@
+package lightstate
+
+import (
+	"time"
+
+	"github.com/mlctrez/vhugo/devicedb"
+)
+
+// LifecycleEvent is published on the added, deleted and renamed subjects of a light.
+type LifecycleEvent struct {
+	Version   int       `json:"version"`
+	Kind      string    `json:"kind"`
+	GroupID   string    `json:"groupID"`
+	LightID   string    `json:"lightID"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// GroupActionEvent is published on the action subject of a hue group when a group action is applied.
+type GroupActionEvent struct {
+	Version      int                    `json:"version"`
+	GroupID      string                 `json:"groupID"`
+	HueGroupID   string                 `json:"hueGroupID"`
+	Name         string                 `json:"name"`
+	Lights       []string               `json:"lights"`
+	StateRequest *devicedb.StateRequest `json:"stateRequest,omitempty"`
+	Scene        string                 `json:"scene,omitempty"`
+	Source       string                 `json:"source"`
+	RemoteAddr   string                 `json:"remoteAddr,omitempty"`
+	Timestamp    time.Time              `json:"timestamp"`
+}
+
+func (s *Service) publishLifecycle(kind string, groupID string, lightID string, vl *devicedb.VirtualLight) {
+	s.NS.Publish(LightSubject(groupID, lightID, kind), &LifecycleEvent{
+		Version:   EventVersion,
+		Kind:      kind,
+		GroupID:   groupID,
+		LightID:   lightID,
+		Name:      vl.Name,
+		Type:      vl.Type,
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+// Add stores a new light in the device group and publishes its added event.
+func (s *Service) Add(groupID string, vl *devicedb.VirtualLight) (lightID string, err error) {
+	if lightID, err = s.DB.AddVirtualLight(groupID, vl); err != nil {
+		return
+	}
+	s.publishLifecycle(KindAdded, groupID, lightID, vl)
+	return
+}
+
+// Edit changes the attributes of a light with fn, publishing a renamed event when the name changed
+// and the lightAttributeChange the web ui listens to.
+func (s *Service) Edit(groupID string, lightID string, fn func(vl *devicedb.VirtualLight) error) (virtualLight *devicedb.VirtualLight, err error) {
+	var previousName string
+	virtualLight, err = s.DB.EditVirtualLight(groupID, lightID, func(vl *devicedb.VirtualLight) error {
+		previousName = vl.Name
+		return fn(vl)
+	})
+	if err != nil {
+		return
+	}
+	if virtualLight.Name != previousName {
+		s.publishLifecycle(KindRenamed, groupID, lightID, virtualLight)
+	}
+	s.NS.Publish("lightAttributeChange", &devicedb.LightAttributeChange{
+		GroupID: groupID, LightID: lightID, Name: virtualLight.Name, Pointsymbol: virtualLight.Pointsymbol,
+	})
+	return
+}
+
+// Delete removes a light from the device group and publishes its deleted event.
+func (s *Service) Delete(groupID string, lightID string) error {
+	vl, err := s.DB.GetVirtualLight(groupID, lightID)
+	if err != nil {
+		return err
+	}
+	if err = s.DB.DeleteVirtualLight(groupID, lightID); err != nil {
+		return err
+	}
+	s.publishLifecycle(KindDeleted, groupID, lightID, vl)
+	return nil
+}
+
+// Move moves a light to another device group, publishing a deleted event for the old light id
+// and an added event for the new one.
+func (s *Service) Move(groupID string, lightID string, toGroupID string) (newLightID string, err error) {
+	vl, err := s.DB.GetVirtualLight(groupID, lightID)
+	if err != nil {
+		return "", err
+	}
+	if newLightID, err = s.DB.MoveVirtualLight(groupID, lightID, toGroupID); err != nil {
+		return "", err
+	}
+	s.publishLifecycle(KindDeleted, groupID, lightID, vl)
+	s.publishLifecycle(KindAdded, toGroupID, newLightID, vl)
+	return
+}
+
+// GroupAction publishes the action applied to the lights of a hue group, either a state request, a scene or both.
+func (s *Service) GroupAction(groupID string, hueGroupID string, hg *devicedb.HueGroup, sr *devicedb.StateRequest, scene string, origin Origin) {
+	event := &GroupActionEvent{
+		Version:    EventVersion,
+		GroupID:    groupID,
+		HueGroupID: hueGroupID,
+		Name:       hg.Name,
+		Lights:     hg.Lights,
+		Scene:      scene,
+		Source:     origin.Source,
+		RemoteAddr: origin.RemoteAddr,
+		Timestamp:  time.Now().UTC(),
+	}
+	if !sr.Empty() {
+		event.StateRequest = sr
+	}
+	s.NS.Publish(GroupSubject(groupID, hueGroupID, KindAction), event)
+}
@@ -0,0 +1,157 @@
+package lightstate
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/vhugo/hlog"
+	"github.com/mlctrez/vhugo/natsserver"
+)
+
+// EventVersion is incremented when a field of Event changes meaning or is removed.
+const EventVersion = 1
+
+// Sources of a light state change.
+const (
+	SourceHueAPI   = "hue-api"
+	SourceWebUI    = "webui"
+	SourceSchedule = "schedule"
+	SourceRule     = "rule"
+	// SourceDevice is a state a device reported after it changed on its own.
+	SourceDevice = "device"
+)
+
+// Origin tells where a state change came from.
+type Origin struct {
+	Source     string
+	RemoteAddr string
+}
+
+// Event is published on the state subject of the light and on lightStateChange for every state change of a light.
+// StateRequest holds the attributes that were set, State is the light state after the change.
+type Event struct {
+	Version      int                        `json:"version"`
+	GroupID      string                     `json:"groupID"`
+	LightID      string                     `json:"lightID"`
+	Name         string                     `json:"name"`
+	StateRequest *devicedb.StateRequest     `json:"stateRequest"`
+	Previous     devicedb.VirtualLightState `json:"previous"`
+	State        devicedb.VirtualLightState `json:"state"`
+	Source       string                     `json:"source"`
+	RemoteAddr   string                     `json:"remoteAddr,omitempty"`
+	Timestamp    time.Time                  `json:"timestamp"`
+}
+
+// Service changes light state for the hue api, the web ui, schedules and rules alike.
+type Service struct {
+	DB     *devicedb.DeviceDB
+	NS     *natsserver.NatsServer
+	logger *hlog.HLog
+}
+
+func New(db *devicedb.DeviceDB, ns *natsserver.NatsServer, logger *log.Logger) *Service {
+	return &Service{DB: db, NS: ns, logger: hlog.New(logger, "LightState")}
+}
+
+// Change applies sr to the light, stores the new state and publishes the change event. Previous and State
+// of the event are taken within the transaction that stores the change, so concurrent changes of a light
+// each report the state they started from.
+// The new state is stored before the event is delivered, so handlers of the event that read the light find
+// the state it describes. Lights that acknowledge state changes go back to the previous state and become
+// unreachable when no reply arrives, and are reachable again once a change is acknowledged.
+func (s *Service) Change(groupID string, lightID string, sr *devicedb.StateRequest, origin Origin) (virtualLight *devicedb.VirtualLight, err error) {
+	if virtualLight, err = s.DB.GetVirtualLight(groupID, lightID); err != nil {
+		return
+	}
+
+	if sr = virtualLight.Profile().Filter(sr); sr.Empty() {
+		// nothing the light type supports, such as a color change of an on/off plug
+		return
+	}
+
+	lo, err := s.DB.GetLightOptions(groupID, lightID)
+	if err != nil {
+		return
+	}
+
+	var event *Event
+	virtualLight, err = s.DB.EditVirtualLight(groupID, lightID, func(vl *devicedb.VirtualLight) error {
+		event = &Event{
+			Version:      EventVersion,
+			GroupID:      groupID,
+			LightID:      lightID,
+			Name:         vl.Name,
+			StateRequest: sr,
+			Previous:     vl.State,
+			Source:       origin.Source,
+			RemoteAddr:   origin.RemoteAddr,
+			Timestamp:    time.Now().UTC(),
+		}
+		vl.UpdateState(sr)
+		event.State = vl.State
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// the light subject carries the acknowledgment, lightStateChange is kept for existing consumers
+	if deliveryErr := s.NS.Deliver(LightSubject(groupID, lightID, KindState), event, lo.Timeout()); deliveryErr != nil {
+		virtualLight, err = s.DB.EditVirtualLight(groupID, lightID, func(vl *devicedb.VirtualLight) error {
+			// the light keeps the state it had unless another change was stored in the meantime
+			if reflect.DeepEqual(vl.State, event.State) {
+				vl.State = event.Previous
+			}
+			vl.State.Reachable = false
+			return nil
+		})
+		if err == nil {
+			err = fmt.Errorf("light %s is unreachable, %v", lightID, deliveryErr)
+		}
+		return
+	}
+
+	if lo.Acknowledge && !virtualLight.State.Reachable {
+		if virtualLight, err = s.DB.EditVirtualLight(groupID, lightID, func(vl *devicedb.VirtualLight) error {
+			vl.State.Reachable = true
+			return nil
+		}); err != nil {
+			return
+		}
+	}
+
+	s.NS.Publish("lightStateChange", event)
+	return
+}
+
+// Report stores the state a device reported and publishes it on lightStateChange with SourceDevice, so
+// the rules and the web ui see the light after the report was stored. Nothing is delivered on the state
+// subject of the light, the device already is in the reported state. Reports of lights that do not
+// exist return an error and are not published.
+func (s *Service) Report(groupID string, lightID string, sr *devicedb.StateRequest) (virtualLight *devicedb.VirtualLight, err error) {
+	var event *Event
+	virtualLight, err = s.DB.EditVirtualLight(groupID, lightID, func(vl *devicedb.VirtualLight) error {
+		event = &Event{
+			Version:      EventVersion,
+			GroupID:      groupID,
+			LightID:      lightID,
+			Name:         vl.Name,
+			StateRequest: sr,
+			Previous:     vl.State,
+			Source:       SourceDevice,
+			Timestamp:    time.Now().UTC(),
+		}
+		vl.UpdateState(sr)
+		vl.State.Reachable = true
+		event.State = vl.State
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	s.NS.Publish("lightStateChange", event)
+	return
+}
@@ -0,0 +1,27 @@
+package lightstate
+
+// Kinds of the per light and per group subjects.
+const (
+	KindState   = "state"
+	KindAdded   = "added"
+	KindDeleted = "deleted"
+	KindRenamed = "renamed"
+	KindAction  = "action"
+)
+
+// LightSubject is the subject of a light event, vhugo.light.<groupID>.<lightID>.<kind>.
+// Subscribe to vhugo.light.*.*.state for the state changes of all lights or to
+// vhugo.light.<groupID>.> for all events of the lights of a device group.
+//
+// Subjects carry the integer light id, not the name, and nats wildcards match whole tokens only, so a
+// subscription such as vhugo.light.*.kitchen-*.state is not possible. Subscribers interested in some
+// lights filter on the lightID of the events, keeping the names of the ids from the added, renamed and
+// deleted events.
+func LightSubject(groupID string, lightID string, kind string) string {
+	return "vhugo.light." + groupID + "." + lightID + "." + kind
+}
+
+// GroupSubject is the subject of a hue group event, vhugo.group.<groupID>.<hueGroupID>.<kind>.
+func GroupSubject(groupID string, hueGroupID string, kind string) string {
+	return "vhugo.group." + groupID + "." + hueGroupID + "." + kind
+}
@@ -0,0 +1,196 @@
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/vhugo/hlog"
+	"github.com/mlctrez/vhugo/lightstate"
+	"github.com/mlctrez/vhugo/natsserver"
+)
+
+// Config of the mqtt broker the bridge connects to, the bridge is disabled without a broker.
+type Config struct {
+	Broker   string
+	ClientID string
+	User     string
+	Password string
+	// Prefix is the first level of the light topics, <prefix>/<groupID>/<lightID>/set and .../state.
+	Prefix string
+}
+
+func (c *Config) Enabled() bool {
+	return c.Broker != ""
+}
+
+// Bridge forwards light state changes to the set topics of mqtt devices and feeds the
+// retained messages of their state topics back as light state reports.
+type Bridge struct {
+	DB     *devicedb.DeviceDB
+	NS     *natsserver.NatsServer
+	config *Config
+	logger *hlog.HLog
+	client mqtt.Client
+
+	lock sync.Mutex
+	// devices are the lights a device published a state for, keyed by groupID/lightID
+	devices map[string]bool
+	// confirms are closed when the device of the light publishes its state
+	confirms map[string][]chan struct{}
+}
+
+func New(config *Config, db *devicedb.DeviceDB, ns *natsserver.NatsServer, logger *log.Logger) *Bridge {
+	if config.Prefix == "" {
+		config.Prefix = "vhugo"
+	}
+	if config.ClientID == "" {
+		config.ClientID = "vhugo"
+	}
+	return &Bridge{
+		DB:       db,
+		NS:       ns,
+		config:   config,
+		logger:   hlog.New(logger, "MQTTBridge"),
+		devices:  make(map[string]bool),
+		confirms: make(map[string][]chan struct{}),
+	}
+}
+
+func (b *Bridge) topic(groupID string, lightID string, kind string) string {
+	return strings.Join([]string{b.config.Prefix, groupID, lightID, kind}, "/")
+}
+
+func (b *Bridge) Run(ctx context.Context) {
+	b.logger.Println("Run() entry", b.config.Broker)
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(b.config.Broker).
+		SetClientID(b.config.ClientID).
+		SetUsername(b.config.User).
+		SetPassword(b.config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			// subscriptions do not survive a reconnect, the retained states are received again
+			stateTopic := b.topic("+", "+", "state")
+			if token := client.Subscribe(stateTopic, 1, b.handleState); token.Wait() && token.Error() != nil {
+				b.logger.Println("Subscribe", stateTopic, token.Error())
+			}
+		})
+	b.client = mqtt.NewClient(opts)
+	if token := b.client.Connect(); token.Wait() && token.Error() != nil {
+		b.logger.Println("Connect", token.Error())
+		return
+	}
+	defer b.client.Disconnect(250)
+
+	subscription, err := b.NS.Subscribe(lightstate.LightSubject("*", "*", lightstate.KindState), b.handleChange)
+	if err != nil {
+		b.logger.Println("Subscribe", err)
+		return
+	}
+	defer subscription.Unsubscribe()
+
+	<-ctx.Done()
+	b.logger.Println("Run() exit")
+}
+
+// handleChange publishes the new state of a light on its set topic. A light that acknowledges state
+// changes is acknowledged once its device published the resulting state on the state topic, lights
+// without a device on the broker are never acknowledged so they become unreachable. The wait runs off
+// the subscription so other lights are not held up.
+func (b *Bridge) handleChange(subject, reply string, event *lightstate.Event) {
+	vl, err := b.DB.GetVirtualLight(event.GroupID, event.LightID)
+	if err != nil {
+		b.logger.Println("GetVirtualLight", event.GroupID, event.LightID, err)
+		return
+	}
+	payloadBytes, err := json.Marshal(payloadFor(event.State, vl.Profile()))
+	if err != nil {
+		b.logger.Println("Marshal", err)
+		return
+	}
+
+	key := event.GroupID + "/" + event.LightID
+	var confirm chan struct{}
+	if reply != "" {
+		b.lock.Lock()
+		if b.devices[key] {
+			confirm = make(chan struct{})
+			b.confirms[key] = append(b.confirms[key], confirm)
+		}
+		b.lock.Unlock()
+	}
+
+	// publishing from the subscription keeps the set messages of a light in order
+	token := b.client.Publish(b.topic(event.GroupID, event.LightID, "set"), 1, false, payloadBytes)
+	go func() {
+		if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+			b.logger.Println("Publish", event.GroupID, event.LightID, token.Error())
+		}
+		if confirm == nil {
+			return
+		}
+		lo, err := b.DB.GetLightOptions(event.GroupID, event.LightID)
+		if err != nil {
+			b.logger.Println("GetLightOptions", event.GroupID, event.LightID, err)
+			return
+		}
+		select {
+		case <-confirm:
+			b.NS.Publish(reply, map[string]string{"bridge": "mqtt"})
+		case <-time.After(lo.Timeout()):
+			b.dropConfirm(key, confirm)
+		}
+	}()
+}
+
+// dropConfirm removes a confirmation that timed out.
+func (b *Bridge) dropConfirm(key string, confirm chan struct{}) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	confirms := b.confirms[key][:0]
+	for _, c := range b.confirms[key] {
+		if c != confirm {
+			confirms = append(confirms, c)
+		}
+	}
+	if len(confirms) == 0 {
+		delete(b.confirms, key)
+		return
+	}
+	b.confirms[key] = confirms
+}
+
+// handleState turns a message of a state topic into a lightStateReport, which stores the state without
+// sending a command back to the device.
+func (b *Bridge) handleState(client mqtt.Client, msg mqtt.Message) {
+	parts := strings.Split(strings.TrimPrefix(msg.Topic(), b.config.Prefix+"/"), "/")
+	if len(parts) != 3 {
+		return
+	}
+	payload := &Payload{}
+	if err := json.Unmarshal(msg.Payload(), payload); err != nil {
+		b.logger.Println("invalid state on", msg.Topic(), err)
+		return
+	}
+	key := parts[0] + "/" + parts[1]
+	b.lock.Lock()
+	b.devices[key] = true
+	for _, confirm := range b.confirms[key] {
+		close(confirm)
+	}
+	delete(b.confirms, key)
+	b.lock.Unlock()
+
+	report := &devicedb.LightStateReport{GroupID: parts[0], LightID: parts[1], StateRequest: payload.stateRequest()}
+	if err := b.NS.Publish("lightStateReport", report); err != nil {
+		b.logger.Println("Publish lightStateReport", err)
+	}
+}
@@ -0,0 +1,87 @@
+package mqttbridge
+
+import (
+	"github.com/mlctrez/vhugo/devicedb"
+)
+
+// Color is the color of a home assistant json schema light, either cie xy or hue/saturation.
+type Color struct {
+	X *float32 `json:"x,omitempty"`
+	Y *float32 `json:"y,omitempty"`
+	H *float32 `json:"h,omitempty"`
+	S *float32 `json:"s,omitempty"`
+}
+
+// Payload is the home assistant json schema light message used on the set and state topics.
+// Brightness uses the hue range of 1 to 254, configure brightness_scale: 254 in home assistant.
+type Payload struct {
+	State      string  `json:"state"`
+	Brightness *int32  `json:"brightness,omitempty"`
+	ColorTemp  *int32  `json:"color_temp,omitempty"`
+	Color      *Color  `json:"color,omitempty"`
+	ColorMode  string  `json:"color_mode,omitempty"`
+	Effect     *string `json:"effect,omitempty"`
+}
+
+// payloadFor converts the state of a light of the profile into the message for its set topic.
+func payloadFor(st devicedb.VirtualLightState, p *devicedb.LightProfile) *Payload {
+	payload := &Payload{State: "OFF", ColorMode: "onoff"}
+	if st.On {
+		payload.State = "ON"
+	}
+	if p.Dimmable {
+		bri := st.Bri
+		payload.Brightness = &bri
+		payload.ColorMode = "brightness"
+	}
+	if p.ColorTemperature && (st.Colormode == "ct" || !p.Color) {
+		ct := st.Ct
+		payload.ColorTemp = &ct
+		payload.ColorMode = "color_temp"
+	}
+	if p.Color {
+		effect := st.Effect
+		payload.Effect = &effect
+		switch st.Colormode {
+		case "hs":
+			h := float32(st.Hue) * 360 / (devicedb.MaxHue + 1)
+			s := float32(st.Sat) * 100 / devicedb.MaxSat
+			payload.Color = &Color{H: &h, S: &s}
+			payload.ColorMode = "hs"
+		case "xy":
+			if len(st.Xy) == 2 {
+				x, y := st.Xy[0], st.Xy[1]
+				payload.Color = &Color{X: &x, Y: &y}
+				payload.ColorMode = "xy"
+			}
+		}
+	}
+	return payload
+}
+
+// stateRequest converts a message from a state topic into the request that brings the light to that state.
+func (payload *Payload) stateRequest() *devicedb.StateRequest {
+	sr := &devicedb.StateRequest{Bri: payload.Brightness, Ct: payload.ColorTemp, Effect: payload.Effect}
+	switch payload.State {
+	case "ON":
+		on := true
+		sr.On = &on
+	case "OFF":
+		on := false
+		sr.On = &on
+	}
+	if c := payload.Color; c != nil {
+		if c.X != nil && c.Y != nil {
+			sr.Xy = []float32{*c.X, *c.Y}
+		}
+		if c.H != nil {
+			hue := int32(*c.H * (devicedb.MaxHue + 1) / 360)
+			sr.Hue = &hue
+		}
+		if c.S != nil {
+			sat := int32(*c.S * devicedb.MaxSat / 100)
+			sr.Sat = &sat
+		}
+	}
+	return sr
+}
@@ -0,0 +1,205 @@
+package natsserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	neturl "net/url"
+
+	"github.com/nats-io/gnatsd/server"
+	"github.com/nats-io/go-nats"
+)
+
+// Config configures the embedded gnatsd server, or the connection to an external broker when URL is set.
+type Config struct {
+	Host string
+	Port int
+
+	// User and Password or Token require clients to authenticate, vhugo connects with the same credentials.
+	User     string
+	Password string
+	Token    string
+
+	// TLSCert and TLSKey enable tls for clients, TLSCA verifies the server certificate. Without TLSCA
+	// vhugo trusts the embedded server by TLSCert alone.
+	TLSCert string
+	TLSKey  string
+	TLSCA   string
+	// TLSVerify makes the embedded server require client certificates signed by TLSCA.
+	TLSVerify bool
+
+	// ClusterPort accepts routes from other servers, Routes is a comma separated list of
+	// nats-route:// urls of the servers to join. Routes authenticate with User and Password and,
+	// when TLSCert is set, use tls with certificates signed by TLSCA in both directions.
+	ClusterPort int
+	Routes      string
+
+	// URL connects to an external broker instead of starting the embedded server.
+	URL string
+}
+
+// External is true when vhugo uses an existing broker instead of the embedded server.
+func (c *Config) External() bool {
+	return c.URL != ""
+}
+
+// Validate checks that the auth, tls and cluster settings are consistent.
+func (c *Config) Validate() error {
+	if c.Token != "" && (c.User != "" || c.Password != "") {
+		return fmt.Errorf("nats token and user/password are exclusive")
+	}
+	if (c.User == "") != (c.Password == "") {
+		return fmt.Errorf("nats user and password must be set together")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("nats tls cert and key must be set together")
+	}
+	if c.TLSVerify && (c.TLSCert == "" || c.TLSCA == "") {
+		return fmt.Errorf("nats tls verify requires the tls cert, key and ca")
+	}
+	if c.External() && c.TLSVerify {
+		return fmt.Errorf("nats tls verify does not apply to an external broker")
+	}
+	if c.External() && c.clustered() {
+		return fmt.Errorf("nats cluster settings do not apply to an external broker")
+	}
+	if c.clustered() && c.User == "" {
+		return fmt.Errorf("nats cluster requires the nats user and password, routes do not accept a token")
+	}
+	if c.clustered() && c.TLSCert != "" && c.TLSCA == "" {
+		return fmt.Errorf("nats cluster with tls requires the tls ca to verify the other servers")
+	}
+	return nil
+}
+
+// clustered is true when the embedded server accepts or solicits routes.
+func (c *Config) clustered() bool {
+	return c.ClusterPort != 0 || c.Routes != ""
+}
+
+// serverOptions are the options of the embedded server.
+func (c *Config) serverOptions() (opts *server.Options, err error) {
+	opts = &server.Options{
+		Host:          c.Host,
+		Port:          c.Port,
+		NoSigs:        true,
+		Username:      c.User,
+		Password:      c.Password,
+		Authorization: c.Token,
+	}
+	if c.TLSCert != "" {
+		tc := &server.TLSConfigOpts{CertFile: c.TLSCert, KeyFile: c.TLSKey, CaFile: c.TLSCA, Verify: c.TLSVerify}
+		if opts.TLSConfig, err = server.GenTLSConfig(tc); err != nil {
+			return nil, err
+		}
+		opts.TLS = true
+		opts.TLSVerify = tc.Verify
+		opts.TLSTimeout = 2
+	}
+	if !c.clustered() {
+		return
+	}
+	if c.ClusterPort != 0 {
+		opts.Cluster.Host = c.Host
+		opts.Cluster.Port = c.ClusterPort
+	}
+	opts.Cluster.Username = c.User
+	opts.Cluster.Password = c.Password
+	if c.TLSCert != "" {
+		tc := &server.TLSConfigOpts{CertFile: c.TLSCert, KeyFile: c.TLSKey, CaFile: c.TLSCA, Verify: true}
+		if opts.Cluster.TLSConfig, err = server.GenTLSConfig(tc); err != nil {
+			return nil, err
+		}
+		// servers are client and server of each other's routes, as in the cluster tls block of a gnatsd config
+		opts.Cluster.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		opts.Cluster.TLSConfig.RootCAs = opts.Cluster.TLSConfig.ClientCAs
+		opts.Cluster.TLSTimeout = 2
+	}
+	if c.Routes != "" {
+		opts.Routes = server.RoutesFromStr(c.Routes)
+		for _, route := range opts.Routes {
+			if route.User == nil {
+				route.User = neturl.UserPassword(c.User, c.Password)
+			}
+		}
+	}
+	return
+}
+
+// clientOptions are the options vhugo connects with, to url which is the embedded server or the external broker.
+func (c *Config) clientOptions(url string) (opts nats.Options, err error) {
+	opts = nats.GetDefaultOptions()
+	opts.Servers = []string{url}
+	opts.Name = "vhugo"
+	opts.User = c.User
+	opts.Password = c.Password
+	opts.Token = c.Token
+
+	if c.TLSCert == "" && c.TLSCA == "" {
+		return
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.TLSCA != "" {
+		caBytes, err := ioutil.ReadFile(c.TLSCA)
+		if err != nil {
+			return opts, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caBytes) {
+			return opts, fmt.Errorf("no certificates in nats tls ca %s", c.TLSCA)
+		}
+	}
+	if c.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return opts, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		if c.TLSCA == "" && !c.External() {
+			// the embedded server presents TLSCert, so trusting it alone verifies the server
+			if tlsConfig.ServerName, err = trustCertificate(tlsConfig, cert, url); err != nil {
+				return opts, err
+			}
+		}
+	}
+	opts.Secure = true
+	opts.TLSConfig = tlsConfig
+	return
+}
+
+// trustCertificate makes cert the only root of tlsConfig and returns the server name to verify it with, the
+// host of url when the certificate names it, otherwise the first name of the certificate since the embedded
+// server is reached by the address it listens on rather than by the tls host.
+func trustCertificate(tlsConfig *tls.Config, cert tls.Certificate, url string) (serverName string, err error) {
+	tlsConfig.RootCAs = x509.NewCertPool()
+	var leaf *x509.Certificate
+	for _, der := range cert.Certificate {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return "", err
+		}
+		if leaf == nil {
+			leaf = c
+		}
+		tlsConfig.RootCAs.AddCert(c)
+	}
+	if leaf == nil {
+		return "", fmt.Errorf("no certificate in nats tls cert")
+	}
+
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	if serverName = u.Hostname(); leaf.VerifyHostname(serverName) == nil {
+		return serverName, nil
+	}
+	if len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames[0], nil
+	}
+	if len(leaf.IPAddresses) > 0 {
+		return leaf.IPAddresses[0].String(), nil
+	}
+	return leaf.Subject.CommonName, nil
+}
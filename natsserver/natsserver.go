@@ -3,6 +3,7 @@ package natsserver
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
@@ -12,7 +13,7 @@ import (
 )
 
 type NatsServer struct {
-	opts      *server.Options
+	config    *Config
 	server    *server.Server
 	conn      *nats.Conn
 	logger    *hlog.HLog
@@ -24,8 +25,8 @@ type NatsPublisher interface {
 	Publish(subject string, v interface{}) error
 }
 
-func New(opts *server.Options, logger *log.Logger) *NatsServer {
-	return &NatsServer{opts: opts, logger: hlog.New(logger, "NatsServer")}
+func New(config *Config, logger *log.Logger) *NatsServer {
+	return &NatsServer{config: config, logger: hlog.New(logger, "NatsServer")}
 }
 
 func (n *NatsServer) Shutdown() {
@@ -57,34 +58,51 @@ func (n *NatsServer) Shutdown() {
 	n.logger.Println("Shutdown() complete")
 }
 
+// Start runs the embedded server, unless the config names an external broker, and connects to it.
 func (n *NatsServer) Start(ctx context.Context) error {
 
-	n.server = server.New(n.opts)
-
-	go n.server.Start()
-
-	if serverReady := n.server.ReadyForConnections(5 * time.Second); !serverReady {
-		n.Shutdown()
-		return errors.New("failed to start server")
+	err := n.config.Validate()
+	if err != nil {
+		return err
 	}
 
-	var err error
+	url := n.config.URL
+	if !n.config.External() {
+		serverOpts, err := n.config.serverOptions()
+		if err != nil {
+			return err
+		}
+		n.server = server.New(serverOpts)
 
-	opts := nats.GetDefaultOptions()
-	opts.Servers = []string{"nats://" + n.server.Addr().String()}
+		go n.server.Start()
 
-	if n.conn, err = opts.Connect(); err != nil {
+		if serverReady := n.server.ReadyForConnections(5 * time.Second); !serverReady {
+			n.Shutdown()
+			return errors.New("failed to start server")
+		}
+		url = "nats://" + n.server.Addr().String()
+	}
+
+	opts, err := n.config.clientOptions(url)
+	if err != nil {
 		n.Shutdown()
 		return err
 	}
-	if n.encConn, err = nats.NewEncodedConn(n.conn, nats.JSON_ENCODER); err != nil {
+	if n.conn, err = opts.Connect(); err != nil {
 		n.Shutdown()
 		return err
 	}
-	if n.loggerSub, err = n.conn.Subscribe(">", n.MessageLogger); err != nil {
+	if n.encConn, err = nats.NewEncodedConn(n.conn, nats.JSON_ENCODER); err != nil {
 		n.Shutdown()
 		return err
 	}
+	// an external broker carries traffic of other applications, only the embedded server is logged
+	if !n.config.External() {
+		if n.loggerSub, err = n.conn.Subscribe(">", n.MessageLogger); err != nil {
+			n.Shutdown()
+			return err
+		}
+	}
 
 	mContext, cancel := context.WithCancel(ctx)
 	go func() {
@@ -101,6 +119,23 @@ func (n *NatsServer) Publish(subject string, v interface{}) error {
 	return n.encConn.Publish(subject, v)
 }
 
+// Deliver publishes v, or sends it as a request when timeout is not zero and returns an error
+// when no subscriber replies in time.
+func (n *NatsServer) Deliver(subject string, v interface{}, timeout time.Duration) error {
+	if timeout == 0 {
+		return n.Publish(subject, v)
+	}
+	// any reply is an acknowledgment, the raw message avoids decoding it
+	ack := &nats.Msg{}
+	if err := n.encConn.Request(subject, v, ack, timeout); err != nil {
+		if err == nats.ErrTimeout {
+			return fmt.Errorf("no acknowledgment on %s within %s", subject, timeout)
+		}
+		return err
+	}
+	return nil
+}
+
 func (n *NatsServer) Subscribe(subject string, cb nats.Handler) (*nats.Subscription, error) {
 	return n.encConn.Subscribe(subject, cb)
 }
@@ -0,0 +1,127 @@
+// Package network picks the interfaces vhugo serves on and the local address a remote host can reach.
+package network
+
+import (
+	"fmt"
+	"net"
+)
+
+// Interface is a network interface with its ipv4 networks.
+type Interface struct {
+	*net.Interface
+	Networks []*net.IPNet
+}
+
+// IP is the first ipv4 address of the interface.
+func (i *Interface) IP() net.IP {
+	return i.Networks[0].IP
+}
+
+// Contains is true when ip is in one of the networks of the interface.
+func (i *Interface) Contains(ip net.IP) bool {
+	for _, n := range i.Networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// LocalIP is the address of the interface on the network of remote, the first address when
+// remote is on none of them.
+func (i *Interface) LocalIP(remote net.IP) net.IP {
+	for _, n := range i.Networks {
+		if n.Contains(remote) {
+			return n.IP
+		}
+	}
+	return i.IP()
+}
+
+func newInterface(iface net.Interface) (*Interface, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	i := &Interface{Interface: &iface}
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok && n.IP.To4() != nil {
+			i.Networks = append(i.Networks, &net.IPNet{IP: n.IP.To4(), Mask: n.Mask})
+		}
+	}
+	return i, nil
+}
+
+// usable is true for an interface that is up, not a loopback and supports multicast.
+func usable(i *Interface) bool {
+	return i.Flags&net.FlagUp != 0 && i.Flags&net.FlagLoopback == 0 &&
+		i.Flags&net.FlagMulticast != 0 && len(i.Networks) > 0
+}
+
+// Select returns the interfaces to serve ssdp on:
+//   - the interface named name when it is not empty,
+//   - otherwise the interface that has ip when it is not empty,
+//   - otherwise every interface that is up, not a loopback, supports multicast and has an ipv4 address.
+//
+// No interfaces and no error are returned when ip is on an interface without multicast support,
+// such as the loopback, the default multicast interface is used then.
+func Select(name string, ip string) (selected []*Interface, err error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		i, err := newInterface(iface)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case name != "":
+			if i.Name != name {
+				continue
+			}
+			if len(i.Networks) == 0 {
+				return nil, fmt.Errorf("interface %s has no ipv4 address", name)
+			}
+			return []*Interface{i}, nil
+		case ip != "":
+			if !i.hasIP(net.ParseIP(ip)) {
+				continue
+			}
+			if i.Flags&net.FlagMulticast == 0 {
+				return nil, nil
+			}
+			return []*Interface{i}, nil
+		case usable(i):
+			selected = append(selected, i)
+		}
+	}
+	switch {
+	case name != "":
+		return nil, fmt.Errorf("interface %s does not exist", name)
+	case ip != "":
+		return nil, fmt.Errorf("no interface has ip %s", ip)
+	case len(selected) == 0:
+		return nil, fmt.Errorf("no interface is up with multicast and an ipv4 address")
+	}
+	return selected, nil
+}
+
+func (i *Interface) hasIP(ip net.IP) bool {
+	for _, n := range i.Networks {
+		if n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Find returns the interface with the index from a list of selected interfaces, nil when it is not in the list.
+func Find(interfaces []*Interface, index int) *Interface {
+	for _, i := range interfaces {
+		if i.Index == index {
+			return i
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mlctrez/vhugo/devicedb"
+)
+
+// event is a change of state attributes of a single resource such as /lights/1 or /sensors/2.
+type event struct {
+	resource string
+	changed  map[string]bool
+}
+
+// snapshot is the state of a device group the conditions of a rule are evaluated against.
+type snapshot struct {
+	now     time.Time
+	lights  map[string]*devicedb.VirtualLight
+	sensors map[string]*devicedb.Sensor
+	groups  map[string]*devicedb.HueGroup
+}
+
+// condition is a parsed rule condition address, /<resource>/<id>/state/<attribute> or /config/localtime.
+type condition struct {
+	*devicedb.RuleCondition
+	kind      string
+	id        string
+	attribute string
+}
+
+func parseCondition(rc *devicedb.RuleCondition) (c *condition, err error) {
+	if rc == nil {
+		return nil, fmt.Errorf("invalid condition, null")
+	}
+	c = &condition{RuleCondition: rc}
+	parts := strings.Split(strings.TrimPrefix(rc.Address, "/"), "/")
+	switch {
+	case rc.Address == "/config/localtime":
+		c.kind = "config"
+		c.attribute = "localtime"
+	case len(parts) == 4 && parts[2] == "state" && (parts[0] == "lights" || parts[0] == "sensors" || parts[0] == "groups"):
+		c.kind, c.id, c.attribute = parts[0], parts[1], parts[3]
+	default:
+		return nil, fmt.Errorf("invalid condition address %s", rc.Address)
+	}
+
+	switch rc.Operator {
+	case "eq", "gt", "lt":
+		if c.kind == "config" || rc.Value == "" {
+			return nil, fmt.Errorf("operator %s requires a value and a state address", rc.Operator)
+		}
+	case "dx":
+		if c.kind == "config" {
+			return nil, fmt.Errorf("operator dx requires a state address")
+		}
+	case "in", "not in":
+		if c.kind != "config" {
+			return nil, fmt.Errorf("operator %s requires the /config/localtime address", rc.Operator)
+		}
+		if _, _, err = parseInterval(rc.Value); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid condition operator %s", rc.Operator)
+	}
+	return
+}
+
+// ValidateCondition returns an error when the condition address, operator or value is not supported.
+func ValidateCondition(rc *devicedb.RuleCondition) error {
+	_, err := parseCondition(rc)
+	return err
+}
+
+// resource is the address of the resource the condition refers to, such as /lights/1.
+func (c *condition) resource() string {
+	return "/" + c.kind + "/" + c.id
+}
+
+// triggeredBy is true when the event changed the attribute the condition refers to.
+func (c *condition) triggeredBy(ev *event, s *snapshot) bool {
+	switch c.kind {
+	case "config":
+		return false
+	case "groups":
+		hg, ok := s.groups[c.id]
+		if !ok || !ev.changed["on"] {
+			return false
+		}
+		for _, lightID := range hg.Lights {
+			if ev.resource == "/lights/"+lightID {
+				return true
+			}
+		}
+		return false
+	default:
+		return ev.resource == c.resource() && ev.changed[c.attribute]
+	}
+}
+
+func (c *condition) holds(ev *event, s *snapshot) bool {
+	switch c.Operator {
+	case "dx":
+		return c.triggeredBy(ev, s)
+	case "in", "not in":
+		from, to, _ := parseInterval(c.Value)
+		local := s.now.In(time.Local)
+		clock := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute +
+			time.Duration(local.Second())*time.Second
+		in := from <= clock && clock < to
+		if from > to {
+			in = clock >= from || clock < to
+		}
+		return in == (c.Operator == "in")
+	}
+
+	value, ok := c.value(s)
+	if !ok {
+		return false
+	}
+	switch c.Operator {
+	case "eq":
+		return fmt.Sprint(value) == c.Value
+	default:
+		actual, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+		if err != nil {
+			return false
+		}
+		expected, err := strconv.ParseFloat(c.Value, 64)
+		if err != nil {
+			return false
+		}
+		if c.Operator == "gt" {
+			return actual > expected
+		}
+		return actual < expected
+	}
+}
+
+// value looks up the current value of the state attribute in the snapshot.
+func (c *condition) value(s *snapshot) (interface{}, bool) {
+	var state interface{}
+	switch c.kind {
+	case "lights":
+		if vl, ok := s.lights[c.id]; ok {
+			state = vl.State
+		}
+	case "sensors":
+		if sensor, ok := s.sensors[c.id]; ok {
+			state = sensor.State
+		}
+	case "groups":
+		if hg, ok := s.groups[c.id]; ok {
+			state = hg.State
+		}
+	}
+	if state == nil {
+		return nil, false
+	}
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		return nil, false
+	}
+	attributes := make(map[string]interface{})
+	if err = json.Unmarshal(stateBytes, &attributes); err != nil {
+		return nil, false
+	}
+	value, ok := attributes[c.attribute]
+	return value, ok && value != nil
+}
+
+// parseInterval parses a local time interval such as T20:00:00/T08:00:00 into times of day.
+func parseInterval(interval string) (from time.Duration, to time.Duration, err error) {
+	parts := strings.Split(interval, "/")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "T") || !strings.HasPrefix(parts[1], "T") {
+		return 0, 0, fmt.Errorf("invalid time interval %s", interval)
+	}
+	if from, err = parseClock(parts[0][1:]); err != nil {
+		return
+	}
+	to, err = parseClock(parts[1][1:])
+	return
+}
+
+func parseClock(clock string) (d time.Duration, err error) {
+	t, err := time.Parse("15:04:05", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s", clock)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second, nil
+}
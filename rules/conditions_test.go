@@ -0,0 +1,100 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mlctrez/vhugo/devicedb"
+)
+
+func testSnapshot(now time.Time) *snapshot {
+	status := 2
+	return &snapshot{
+		now: now,
+		lights: map[string]*devicedb.VirtualLight{
+			"1": {State: devicedb.VirtualLightState{On: true, Bri: 100}},
+		},
+		sensors: map[string]*devicedb.Sensor{
+			"5": {State: devicedb.SensorState{Status: &status}},
+		},
+		groups: map[string]*devicedb.HueGroup{},
+	}
+}
+
+func TestConditionOperators(t *testing.T) {
+	evening := time.Date(2024, 1, 1, 21, 0, 0, 0, time.Local)
+	noon := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	briChanged := &event{resource: "/lights/1", changed: map[string]bool{"bri": true}}
+	otherLight := &event{resource: "/lights/2", changed: map[string]bool{"bri": true}}
+
+	tests := []struct {
+		name     string
+		address  string
+		operator string
+		value    string
+		ev       *event
+		now      time.Time
+		holds    bool
+	}{
+		{"eq bool", "/lights/1/state/on", "eq", "true", briChanged, noon, true},
+		{"eq bool mismatch", "/lights/1/state/on", "eq", "false", briChanged, noon, false},
+		{"eq number", "/lights/1/state/bri", "eq", "100", briChanged, noon, true},
+		{"eq sensor", "/sensors/5/state/status", "eq", "2", briChanged, noon, true},
+		{"eq missing light", "/lights/9/state/on", "eq", "true", briChanged, noon, false},
+		{"eq unset attribute", "/sensors/5/state/flag", "eq", "true", briChanged, noon, false},
+		{"gt", "/lights/1/state/bri", "gt", "99", briChanged, noon, true},
+		{"gt equal", "/lights/1/state/bri", "gt", "100", briChanged, noon, false},
+		{"gt not a number", "/lights/1/state/on", "gt", "1", briChanged, noon, false},
+		{"lt", "/lights/1/state/bri", "lt", "101", briChanged, noon, true},
+		{"lt equal", "/lights/1/state/bri", "lt", "100", briChanged, noon, false},
+		{"dx changed", "/lights/1/state/bri", "dx", "", briChanged, noon, true},
+		{"dx other attribute", "/lights/1/state/on", "dx", "", briChanged, noon, false},
+		{"dx other light", "/lights/1/state/bri", "dx", "", otherLight, noon, false},
+		{"in", "/config/localtime", "in", "T20:00:00/T23:00:00", briChanged, evening, true},
+		{"in outside", "/config/localtime", "in", "T20:00:00/T23:00:00", briChanged, noon, false},
+		{"in over midnight", "/config/localtime", "in", "T20:00:00/T08:00:00", briChanged, evening, true},
+		{"in over midnight outside", "/config/localtime", "in", "T20:00:00/T08:00:00", briChanged, noon, false},
+		{"not in", "/config/localtime", "not in", "T20:00:00/T23:00:00", briChanged, noon, true},
+		{"not in inside", "/config/localtime", "not in", "T20:00:00/T23:00:00", briChanged, evening, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCondition(&devicedb.RuleCondition{Address: tt.address, Operator: tt.operator, Value: tt.value})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if holds := c.holds(tt.ev, testSnapshot(tt.now)); holds != tt.holds {
+				t.Errorf("holds = %v, want %v", holds, tt.holds)
+			}
+		})
+	}
+}
+
+func TestConditionInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		address  string
+		operator string
+		value    string
+	}{
+		{"unknown operator", "/lights/1/state/on", "ne", "true"},
+		{"eq without value", "/lights/1/state/on", "eq", ""},
+		{"eq on localtime", "/config/localtime", "eq", "T20:00:00"},
+		{"dx on localtime", "/config/localtime", "dx", ""},
+		{"in on state", "/lights/1/state/on", "in", "T20:00:00/T23:00:00"},
+		{"in bad interval", "/config/localtime", "in", "20:00:00/23:00:00"},
+		{"in bad clock", "/config/localtime", "in", "T25:00:00/T23:00:00"},
+		{"not a state address", "/lights/1/name", "eq", "x"},
+		{"unknown resource", "/scenes/1/state/on", "eq", "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateCondition(&devicedb.RuleCondition{Address: tt.address, Operator: tt.operator, Value: tt.value}); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+	if err := ValidateCondition(nil); err == nil {
+		t.Error("expected an error for a null condition")
+	}
+}
@@ -0,0 +1,90 @@
+package rules
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mlctrez/vhugo/devicedb"
+)
+
+// parseCoordinate parses a hue coordinate such as 052.3700N or 004.8900W, plain signed degrees are accepted too.
+func parseCoordinate(coordinate string) (float64, error) {
+	sign := 1.0
+	c := strings.TrimSpace(coordinate)
+	if c != "" {
+		switch c[len(c)-1] {
+		case 'S', 's', 'W', 'w':
+			sign = -1
+			c = c[:len(c)-1]
+		case 'N', 'n', 'E', 'e':
+			c = c[:len(c)-1]
+		}
+	}
+	v, err := strconv.ParseFloat(c, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid coordinate %s", coordinate)
+	}
+	return sign * v, nil
+}
+
+// sunTimes computes the sunrise and sunset of the day containing t using the sunrise equation.
+// The boolean is false during polar day or night, when up tells if the sun stays up all day.
+func sunTimes(t time.Time, lat, long float64) (rise, set time.Time, up bool, ok bool) {
+	const rad = math.Pi / 180
+
+	// the julian date of midnight rounds up to the day number of the solar noon of that day
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	julian := float64(midnight.Unix())/86400 + 2440587.5
+	n := math.Ceil(julian - 2451545.0 + 0.0008)
+
+	meanSolarNoon := n - long/360
+	m := math.Mod(357.5291+0.98560028*meanSolarNoon, 360)
+	center := 1.9148*math.Sin(m*rad) + 0.02*math.Sin(2*m*rad) + 0.0003*math.Sin(3*m*rad)
+	lambda := math.Mod(m+center+180+102.9372, 360)
+	transit := 2451545.0 + meanSolarNoon + 0.0053*math.Sin(m*rad) - 0.0069*math.Sin(2*lambda*rad)
+
+	declination := math.Asin(math.Sin(lambda*rad) * math.Sin(23.44*rad))
+	cosHourAngle := (math.Sin(-0.83*rad) - math.Sin(lat*rad)*math.Sin(declination)) /
+		(math.Cos(lat*rad) * math.Cos(declination))
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, time.Time{}, cosHourAngle < -1, false
+	}
+	hourAngle := math.Acos(cosHourAngle) / rad
+
+	fromJulian := func(j float64) time.Time {
+		return time.Unix(int64((j-2440587.5)*86400), 0)
+	}
+	return fromJulian(transit - hourAngle/360), fromJulian(transit + hourAngle/360), false, true
+}
+
+// daylight computes the daylight state of a configured daylight sensor, nil when it is not configured.
+func daylight(sensor *devicedb.Sensor, now time.Time) *bool {
+	cfg := sensor.Config
+	if cfg.Lat == nil || cfg.Long == nil {
+		return nil
+	}
+	lat, err := parseCoordinate(*cfg.Lat)
+	if err != nil {
+		return nil
+	}
+	long, err := parseCoordinate(*cfg.Long)
+	if err != nil {
+		return nil
+	}
+
+	rise, set, up, ok := sunTimes(now, lat, long)
+	if !ok {
+		return &up
+	}
+	if cfg.SunriseOffset != nil {
+		rise = rise.Add(time.Duration(*cfg.SunriseOffset) * time.Minute)
+	}
+	if cfg.SunsetOffset != nil {
+		set = set.Add(-time.Duration(*cfg.SunsetOffset) * time.Minute)
+	}
+	isDaylight := now.After(rise) && now.Before(set)
+	return &isDaylight
+}
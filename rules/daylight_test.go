@@ -0,0 +1,86 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mlctrez/vhugo/devicedb"
+)
+
+func TestParseCoordinate(t *testing.T) {
+	tests := []struct {
+		coordinate string
+		want       float64
+	}{
+		{"052.3700N", 52.37},
+		{"004.8900E", 4.89},
+		{"033.8700S", -33.87},
+		{"118.2400W", -118.24},
+		{"-12.5", -12.5},
+	}
+	for _, tt := range tests {
+		if got, err := parseCoordinate(tt.coordinate); err != nil || got != tt.want {
+			t.Errorf("parseCoordinate(%q) = %v, %v, want %v", tt.coordinate, got, err, tt.want)
+		}
+	}
+	if _, err := parseCoordinate("north"); err == nil {
+		t.Error("expected an error for an invalid coordinate")
+	}
+}
+
+func TestSunTimes(t *testing.T) {
+	within := func(t *testing.T, name string, got time.Time, want time.Time) {
+		if d := got.Sub(want); d < -3*time.Minute || d > 3*time.Minute {
+			t.Errorf("%s = %s, want %s", name, got.UTC(), want)
+		}
+	}
+
+	// Amsterdam on the summer solstice of 2024, sunrise 05:18 and sunset 22:06 CEST
+	day := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+	rise, set, _, ok := sunTimes(day, 52.37, 4.89)
+	if !ok {
+		t.Fatal("expected a sunrise and sunset")
+	}
+	within(t, "sunrise", rise, time.Date(2024, 6, 21, 3, 18, 0, 0, time.UTC))
+	within(t, "sunset", set, time.Date(2024, 6, 21, 20, 6, 0, 0, time.UTC))
+
+	// polar day and night at Longyearbyen
+	if _, _, up, ok := sunTimes(day, 78.22, 15.65); ok || !up {
+		t.Errorf("midsummer at 78N: ok = %v, up = %v, want polar day", ok, up)
+	}
+	winter := time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC)
+	if _, _, up, ok := sunTimes(winter, 78.22, 15.65); ok || up {
+		t.Errorf("midwinter at 78N: ok = %v, up = %v, want polar night", ok, up)
+	}
+}
+
+func TestDaylight(t *testing.T) {
+	lat, long := "052.3700N", "004.8900E"
+	sensor := &devicedb.Sensor{Config: devicedb.SensorConfig{On: true, Lat: &lat, Long: &long}}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want bool
+	}{
+		{"noon", time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC), true},
+		{"before sunrise", time.Date(2024, 6, 21, 3, 0, 0, 0, time.UTC), false},
+		{"after sunset", time.Date(2024, 6, 21, 20, 30, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		if got := daylight(sensor, tt.now); got == nil || *got != tt.want {
+			t.Errorf("%s: daylight = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// a sunset offset ends daylight that many minutes before sunset
+	offset := 60
+	sensor.Config.SunsetOffset = &offset
+	if got := daylight(sensor, time.Date(2024, 6, 21, 19, 30, 0, 0, time.UTC)); got == nil || *got {
+		t.Errorf("daylight with a sunset offset = %v, want false", got)
+	}
+
+	if got := daylight(&devicedb.Sensor{}, time.Now()); got != nil {
+		t.Errorf("daylight without a location = %v, want nil", *got)
+	}
+}
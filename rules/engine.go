@@ -0,0 +1,242 @@
+package rules
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/vhugo/hlog"
+	"github.com/mlctrez/vhugo/lightstate"
+	"github.com/mlctrez/vhugo/natsserver"
+)
+
+// Executor runs a rule action against the lights, groups and sensors of a device group.
+type Executor interface {
+	Execute(cmd *devicedb.Command, source string) error
+}
+
+// SensorStateChange is published on the sensorStateChange subject when sensor state attributes change.
+type SensorStateChange struct {
+	GroupID  string   `json:"groupID"`
+	SensorID string   `json:"sensorID"`
+	Changed  []string `json:"changed"`
+}
+
+type lightStateChange struct {
+	GroupID      string                 `json:"groupID"`
+	LightID      string                 `json:"lightID"`
+	StateRequest map[string]interface{} `json:"stateRequest"`
+	Source       string                 `json:"source"`
+}
+
+// Engine evaluates the rules of a device group whenever a light or sensor state of the group changes.
+type Engine struct {
+	DB        *devicedb.DeviceDB
+	NS        *natsserver.NatsServer
+	logger    *hlog.HLog
+	lock      sync.Mutex
+	executors map[string]Executor
+}
+
+func New(db *devicedb.DeviceDB, ns *natsserver.NatsServer, logger *log.Logger) *Engine {
+	return &Engine{
+		DB:        db,
+		NS:        ns,
+		logger:    hlog.New(logger, "RulesEngine"),
+		executors: make(map[string]Executor),
+	}
+}
+
+func (e *Engine) Register(groupID string, ex Executor) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.executors[groupID] = ex
+}
+
+// Unregister stops evaluating the rules of a device group.
+func (e *Engine) Unregister(groupID string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	delete(e.executors, groupID)
+}
+
+func (e *Engine) Run(ctx context.Context) {
+	e.logger.Println("Run() entry")
+
+	// state reports of devices are published here with the device source once stored, so they trigger
+	// rules like the changes made through the api
+	lightSub, err := e.NS.Subscribe("lightStateChange", func(lsc *lightStateChange) {
+		// light changes made by rule actions do not fire rules again, so rules cannot loop on each other
+		if lsc.Source == lightstate.SourceRule {
+			return
+		}
+		changed := make(map[string]bool)
+		for attribute, value := range lsc.StateRequest {
+			if value != nil {
+				changed[strings.TrimSuffix(attribute, "_inc")] = true
+			}
+		}
+		e.handle(lsc.GroupID, &event{resource: "/lights/" + lsc.LightID, changed: changed})
+	})
+	if err != nil {
+		e.logger.Println("Subscribe lightStateChange", err)
+		return
+	}
+	defer lightSub.Unsubscribe()
+
+	sensorSub, err := e.NS.Subscribe("sensorStateChange", func(ssc *SensorStateChange) {
+		changed := make(map[string]bool)
+		for _, attribute := range ssc.Changed {
+			changed[attribute] = true
+		}
+		e.handle(ssc.GroupID, &event{resource: "/sensors/" + ssc.SensorID, changed: changed})
+	})
+	if err != nil {
+		e.logger.Println("Subscribe sensorStateChange", err)
+		return
+	}
+	defer sensorSub.Unsubscribe()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	e.updateDaylight(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			e.logger.Println("Run() exit")
+			return
+		case now := <-ticker.C:
+			e.updateDaylight(now)
+		}
+	}
+}
+
+// handle fires the enabled rules of the group whose conditions all hold and one of which the event triggered.
+// The rules are matched under the lock, their actions run after it is released, in rule id order.
+func (e *Engine) handle(groupID string, ev *event) {
+	ex, fired := e.fire(groupID, ev)
+	for _, f := range fired {
+		e.logger.Println("firing rule", groupID, f.ruleID, f.rule.Name, "on", ev.resource)
+		for _, action := range f.rule.Actions {
+			cmd := &devicedb.Command{Address: "/api/" + f.rule.Owner + action.Address, Method: action.Method, Body: action.Body}
+			if err := ex.Execute(cmd, lightstate.SourceRule); err != nil {
+				e.logger.Println("rule", f.ruleID, "action", action.Address, err)
+			}
+		}
+	}
+}
+
+type firedRule struct {
+	ruleID string
+	rule   *devicedb.Rule
+}
+
+// fire returns the rules of the group the event fires in rule id order, recording their trigger time and count.
+func (e *Engine) fire(groupID string, ev *event) (ex Executor, fired []firedRule) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	ex, ok := e.executors[groupID]
+	if !ok {
+		return nil, nil
+	}
+	rules, err := e.DB.GetRules(groupID)
+	if err != nil {
+		e.logger.Println("GetRules", groupID, err)
+		return nil, nil
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	s, err := e.snapshot(groupID)
+	if err != nil {
+		e.logger.Println("snapshot", groupID, err)
+		return nil, nil
+	}
+
+	for ruleID, rule := range rules {
+		if rule.Status != "enabled" || !e.matches(rule, ev, s) {
+			continue
+		}
+		rule.LastTriggered = s.now.UTC().Format(devicedb.HueTime)
+		rule.TimesTriggered++
+		if err = e.DB.UpdateRule(groupID, ruleID, rule); err != nil {
+			e.logger.Println("UpdateRule", groupID, ruleID, err)
+		}
+		fired = append(fired, firedRule{ruleID: ruleID, rule: rule})
+	}
+	sort.Slice(fired, func(i, j int) bool { return devicedb.IDLess(fired[i].ruleID, fired[j].ruleID) })
+	return ex, fired
+}
+
+func (e *Engine) matches(rule *devicedb.Rule, ev *event, s *snapshot) bool {
+	triggered := false
+	for _, rc := range rule.Conditions {
+		c, err := parseCondition(rc)
+		if err != nil || !c.holds(ev, s) {
+			return false
+		}
+		triggered = triggered || c.triggeredBy(ev, s)
+	}
+	return triggered
+}
+
+func (e *Engine) snapshot(groupID string) (s *snapshot, err error) {
+	s = &snapshot{now: time.Now()}
+	if s.lights, err = e.DB.GetVirtualLights(groupID); err != nil {
+		return
+	}
+	if s.sensors, err = e.DB.GetSensors(groupID); err != nil {
+		return
+	}
+	if s.groups, err = e.DB.GetHueGroups(groupID); err != nil {
+		return
+	}
+	if s.groups[devicedb.AllLightsGroup], err = e.DB.AllLightsHueGroup(groupID); err != nil {
+		return
+	}
+	for _, hg := range s.groups {
+		hg.UpdateGroupState(s.lights)
+	}
+	return
+}
+
+// updateDaylight recomputes the configured daylight sensors, publishing a sensorStateChange when daylight flips.
+func (e *Engine) updateDaylight(now time.Time) {
+	e.lock.Lock()
+	groupIDs := make([]string, 0, len(e.executors))
+	for groupID := range e.executors {
+		groupIDs = append(groupIDs, groupID)
+	}
+	e.lock.Unlock()
+
+	for _, groupID := range groupIDs {
+		sensors, err := e.DB.GetSensors(groupID)
+		if err != nil {
+			e.logger.Println("GetSensors", groupID, err)
+			continue
+		}
+		for sensorID, sensor := range sensors {
+			if sensor.Type != devicedb.SensorDaylight || !sensor.Config.On {
+				continue
+			}
+			isDaylight := daylight(sensor, now)
+			if isDaylight == nil || (sensor.State.Daylight != nil && *sensor.State.Daylight == *isDaylight) {
+				continue
+			}
+			sensor.State.Daylight = isDaylight
+			sensor.Touch()
+			if err = e.DB.UpdateSensor(groupID, sensorID, sensor); err != nil {
+				e.logger.Println("UpdateSensor", groupID, sensorID, err)
+				continue
+			}
+			e.NS.Publish("sensorStateChange", &SensorStateChange{
+				GroupID: groupID, SensorID: sensorID, Changed: []string{"daylight", "lastupdated"},
+			})
+		}
+	}
+}
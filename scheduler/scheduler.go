@@ -0,0 +1,176 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/vhugo/hlog"
+	"github.com/mlctrez/vhugo/lightstate"
+)
+
+// Executor runs a schedule command against the lights and groups of a device group.
+type Executor interface {
+	Execute(cmd *devicedb.Command, source string) error
+}
+
+// Scheduler fires the commands of the enabled schedules stored for each registered device group.
+type Scheduler struct {
+	DB        *devicedb.DeviceDB
+	logger    *hlog.HLog
+	lock      sync.Mutex
+	executors map[string]Executor
+	pending   map[string]*pending
+	lastCheck time.Time
+}
+
+// pending is the next trigger of a schedule, recomputed when its localtime or starttime change.
+type pending struct {
+	localTime string
+	startTime string
+	pattern   *TimePattern
+	start     time.Time
+	base      time.Time
+	at        time.Time
+}
+
+func New(db *devicedb.DeviceDB, logger *log.Logger) *Scheduler {
+	return &Scheduler{
+		DB:        db,
+		logger:    hlog.New(logger, "Scheduler"),
+		executors: make(map[string]Executor),
+		pending:   make(map[string]*pending),
+	}
+}
+
+func (s *Scheduler) Register(groupID string, e Executor) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.executors[groupID] = e
+}
+
+// Unregister stops running the schedules of a device group.
+func (s *Scheduler) Unregister(groupID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.executors, groupID)
+}
+
+func (s *Scheduler) Run(ctx context.Context) {
+	s.logger.Println("Run() entry")
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Println("Run() exit")
+			return
+		case now := <-ticker.C:
+			s.check(now)
+		}
+	}
+}
+
+func (s *Scheduler) check(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// schedules created or changed since the last check are planned from that check so none are skipped
+	since := s.lastCheck
+	if since.IsZero() {
+		since = now
+	}
+	s.lastCheck = now
+
+	seen := make(map[string]bool)
+	for groupID, e := range s.executors {
+		schedules, err := s.DB.GetSchedules(groupID)
+		if err != nil {
+			s.logger.Println("GetSchedules", groupID, err)
+			continue
+		}
+		for scheduleID, schedule := range schedules {
+			key := groupID + "/" + scheduleID
+			if schedule.Status != "enabled" {
+				continue
+			}
+			seen[key] = true
+
+			p := s.pending[key]
+			if p == nil || p.localTime != schedule.LocalTime || p.startTime != schedule.StartTime {
+				var ok bool
+				if p, ok = s.plan(schedule, since); !ok {
+					delete(s.pending, key)
+					s.finish(groupID, scheduleID, schedule)
+					continue
+				}
+				s.pending[key] = p
+			}
+			if now.Before(p.at) {
+				continue
+			}
+
+			s.logger.Println("firing schedule", key, schedule.Name, schedule.Command.Method, schedule.Command.Address)
+			if err = e.Execute(schedule.Command, lightstate.SourceSchedule); err != nil {
+				s.logger.Println("Execute", key, err)
+			}
+
+			if next, ok := p.pattern.Next(p.base, p.start); ok {
+				p.base = next
+				p.at = next.Add(randomDuration(p.pattern.Random))
+			} else {
+				delete(s.pending, key)
+				s.finish(groupID, scheduleID, schedule)
+			}
+		}
+	}
+	for key := range s.pending {
+		if !seen[key] {
+			delete(s.pending, key)
+		}
+	}
+}
+
+// plan computes the first trigger after since, the boolean is false when the schedule will never trigger.
+func (s *Scheduler) plan(schedule *devicedb.Schedule, since time.Time) (p *pending, ok bool) {
+	tp, err := ParseTimePattern(schedule.LocalTime)
+	if err != nil {
+		s.logger.Println("plan", schedule.Name, err)
+		return nil, false
+	}
+	p = &pending{localTime: schedule.LocalTime, startTime: schedule.StartTime, pattern: tp, start: since}
+	for _, t := range []string{schedule.StartTime, schedule.Created} {
+		if start, err := time.ParseInLocation(devicedb.HueTime, t, time.UTC); err == nil {
+			p.start = start
+			break
+		}
+	}
+	if p.base, ok = tp.Next(since, p.start); ok {
+		p.at = p.base.Add(randomDuration(tp.Random))
+	}
+	return
+}
+
+// finish disables or deletes a schedule that will not trigger again.
+func (s *Scheduler) finish(groupID string, scheduleID string, schedule *devicedb.Schedule) {
+	var err error
+	if schedule.AutoDelete {
+		err = s.DB.DeleteSchedule(groupID, scheduleID)
+	} else {
+		schedule.Status = "disabled"
+		err = s.DB.UpdateSchedule(groupID, scheduleID, schedule)
+	}
+	if err != nil {
+		s.logger.Println("finish", groupID, scheduleID, err)
+	}
+}
+
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
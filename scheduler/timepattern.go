@@ -0,0 +1,146 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	absolute = iota
+	weekly
+	timer
+)
+
+// TimePattern is a parsed hue schedule localtime, one of
+//
+//	2006-01-02T15:04:05       absolute time
+//	W127/T15:04:05            weekdays bitmask, Monday is 64 and Sunday is 1
+//	PT00:10:00                timer, R05/PT00:10:00 repeats five times and R/PT00:10:00 forever
+//
+// each optionally followed by A00:30:00 to randomize the time within the interval.
+type TimePattern struct {
+	kind        int
+	at          time.Time
+	weekdays    int
+	clock       time.Duration
+	timer       time.Duration
+	recurrences int
+	Random      time.Duration
+}
+
+func ParseTimePattern(pattern string) (tp *TimePattern, err error) {
+	tp = &TimePattern{}
+	p := pattern
+
+	if i := strings.Index(p, "A"); i > 0 {
+		if tp.Random, err = parseClock(p[i+1:]); err != nil {
+			return nil, fmt.Errorf("invalid time pattern %q: %v", pattern, err)
+		}
+		p = p[:i]
+	}
+
+	switch {
+	case strings.HasPrefix(p, "W"):
+		tp.kind = weekly
+		parts := strings.SplitN(p[1:], "/T", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid time pattern %q", pattern)
+		}
+		if tp.weekdays, err = strconv.Atoi(parts[0]); err != nil || tp.weekdays < 1 || tp.weekdays > 127 {
+			return nil, fmt.Errorf("invalid weekdays in time pattern %q", pattern)
+		}
+		if tp.clock, err = parseClock(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid time pattern %q: %v", pattern, err)
+		}
+	case strings.HasPrefix(p, "R"):
+		tp.kind = timer
+		parts := strings.SplitN(p[1:], "/PT", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid time pattern %q", pattern)
+		}
+		if parts[0] == "" {
+			tp.recurrences = 0
+		} else if tp.recurrences, err = strconv.Atoi(parts[0]); err != nil || tp.recurrences < 1 {
+			return nil, fmt.Errorf("invalid recurrences in time pattern %q", pattern)
+		}
+		if tp.timer, err = parseClock(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid time pattern %q: %v", pattern, err)
+		}
+	case strings.HasPrefix(p, "PT"):
+		tp.kind = timer
+		tp.recurrences = 1
+		if tp.timer, err = parseClock(p[2:]); err != nil {
+			return nil, fmt.Errorf("invalid time pattern %q: %v", pattern, err)
+		}
+	default:
+		tp.kind = absolute
+		if tp.at, err = time.ParseInLocation("2006-01-02T15:04:05", p, time.Local); err != nil {
+			return nil, fmt.Errorf("invalid time pattern %q", pattern)
+		}
+	}
+
+	if tp.kind == timer && tp.timer <= 0 {
+		return nil, fmt.Errorf("invalid timer in time pattern %q", pattern)
+	}
+	return
+}
+
+// IsTimer is true for PT and R/PT patterns which count from the schedule start time.
+func (tp *TimePattern) IsTimer() bool {
+	return tp.kind == timer
+}
+
+// Next returns the first time after the provided time the pattern triggers, not including the random
+// interval. Timers count from start. The boolean is false when the pattern will not trigger again.
+func (tp *TimePattern) Next(after time.Time, start time.Time) (time.Time, bool) {
+	switch tp.kind {
+	case absolute:
+		return tp.at, tp.at.After(after)
+	case weekly:
+		local := after.In(time.Local)
+		day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local)
+		for d := 0; d <= 7; d++ {
+			candidate := day.AddDate(0, 0, d).Add(tp.clock)
+			if candidate.After(after) && tp.weekdays&weekdayBit(candidate.Weekday()) != 0 {
+				return candidate, true
+			}
+		}
+		return time.Time{}, false
+	default:
+		k := int64(1)
+		if after.After(start) {
+			k = int64(after.Sub(start)/tp.timer) + 1
+		}
+		if tp.recurrences > 0 && k > int64(tp.recurrences) {
+			return time.Time{}, false
+		}
+		return start.Add(time.Duration(k) * tp.timer), true
+	}
+}
+
+// weekdayBit maps a weekday to the hue bitmask where Monday is 64 and Sunday is 1.
+func weekdayBit(wd time.Weekday) int {
+	if wd == time.Sunday {
+		return 1
+	}
+	return 1 << uint(7-wd)
+}
+
+// parseClock parses hh:mm:ss into a duration.
+func parseClock(clock string) (d time.Duration, err error) {
+	parts := strings.Split(clock, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time %q", clock)
+	}
+	units := []time.Duration{time.Hour, time.Minute, time.Second}
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 || (i > 0 && v > 59) {
+			return 0, fmt.Errorf("invalid time %q", clock)
+		}
+		d += time.Duration(v) * units[i]
+	}
+	return
+}
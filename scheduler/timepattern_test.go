@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimePatternNext(t *testing.T) {
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2024, 6, day, hour, minute, 0, 0, time.Local)
+	}
+	// June 16 2024 is a Sunday
+	start := at(16, 8, 0)
+
+	tests := []struct {
+		name    string
+		pattern string
+		after   time.Time
+		next    time.Time
+		ok      bool
+		timer   bool
+	}{
+		{"absolute", "2024-06-20T07:30:00", at(16, 12, 0), at(20, 7, 30), true, false},
+		{"absolute passed", "2024-06-20T07:30:00", at(21, 12, 0), at(20, 7, 30), false, false},
+		{"weekly every day", "W127/T08:00:00", at(17, 7, 0), at(17, 8, 0), true, false},
+		{"weekly every day passed", "W127/T08:00:00", at(17, 9, 0), at(18, 8, 0), true, false},
+		{"weekly sunday is 1", "W1/T08:00:00", at(15, 12, 0), at(16, 8, 0), true, false},
+		{"weekly sunday next week", "W1/T08:00:00", at(16, 9, 0), at(23, 8, 0), true, false},
+		{"weekly monday is 64", "W64/T06:30:00", at(16, 12, 0), at(17, 6, 30), true, false},
+		{"weekly saturday is 2", "W2/T22:00:00", at(17, 12, 0), at(22, 22, 0), true, false},
+		{"weekly weekend", "W3/T10:00:00", at(15, 11, 0), at(16, 10, 0), true, false},
+		{"weekly random", "W127/T08:00:00A00:30:00", at(17, 7, 0), at(17, 8, 0), true, false},
+		{"timer", "PT00:10:00", start, start.Add(10 * time.Minute), true, true},
+		{"timer done", "PT00:10:00", start.Add(10 * time.Minute), time.Time{}, false, true},
+		{"timer random", "PT00:10:00A00:01:00", start, start.Add(10 * time.Minute), true, true},
+		{"recurring timer", "R05/PT00:10:00", start.Add(45 * time.Minute), start.Add(50 * time.Minute), true, true},
+		{"recurring timer done", "R05/PT00:10:00", start.Add(50 * time.Minute), time.Time{}, false, true},
+		{"endless timer", "R/PT00:10:00", start.Add(10 * time.Hour), start.Add(10*time.Hour + 10*time.Minute), true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp, err := ParseTimePattern(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tp.IsTimer() != tt.timer {
+				t.Errorf("IsTimer = %v, want %v", tp.IsTimer(), tt.timer)
+			}
+			next, ok := tp.Next(tt.after, start)
+			if ok != tt.ok {
+				t.Fatalf("Next ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !next.Equal(tt.next) {
+				t.Errorf("Next = %s, want %s", next, tt.next)
+			}
+		})
+	}
+}
+
+func TestTimePatternRandom(t *testing.T) {
+	tests := []struct {
+		pattern string
+		random  time.Duration
+	}{
+		{"W127/T08:00:00", 0},
+		{"W127/T08:00:00A00:30:00", 30 * time.Minute},
+		{"2024-06-20T07:30:00A01:00:00", time.Hour},
+		{"R/PT00:10:00A00:00:30", 30 * time.Second},
+	}
+	for _, tt := range tests {
+		tp, err := ParseTimePattern(tt.pattern)
+		if err != nil {
+			t.Errorf("%s: %v", tt.pattern, err)
+			continue
+		}
+		if tp.Random != tt.random {
+			t.Errorf("%s: Random = %s, want %s", tt.pattern, tp.Random, tt.random)
+		}
+	}
+}
+
+func TestTimePatternInvalid(t *testing.T) {
+	for _, pattern := range []string{
+		"",
+		"tomorrow",
+		"2024-13-01T00:00:00",
+		"2024-06-20 07:30:00",
+		"W0/T08:00:00",
+		"W128/T08:00:00",
+		"Wx/T08:00:00",
+		"W127/08:00:00",
+		"W127/T08:60:00",
+		"W127/T08:00",
+		"PT00:00:00",
+		"PT10:00",
+		"R0/PT00:10:00",
+		"Rx/PT00:10:00",
+		"R05/T00:10:00",
+		"W127/T08:00:00A",
+		"PT00:10:00A00:99:00",
+	} {
+		if _, err := ParseTimePattern(pattern); err == nil {
+			t.Errorf("ParseTimePattern(%q) did not fail", pattern)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Package ssdp parses M-SEARCH requests and builds the search responses and NOTIFY announcements
+// of a device as described in the UPnP Device Architecture.
+package ssdp
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Search targets and notification sub types.
+const (
+	All        = "ssdp:all"
+	RootDevice = "upnp:rootdevice"
+	DeviceType = "urn:schemas-upnp-org:device:basic:1"
+	Alive      = "ssdp:alive"
+	ByeBye     = "ssdp:byebye"
+)
+
+// MaxAge is the number of seconds an announcement is valid, devices announce themselves again
+// every AnnounceInterval. MaxMX caps the response delay a search may ask for.
+const (
+	MaxAge           = 1800
+	AnnounceInterval = MaxAge / 3 * time.Second
+	MaxMX            = 5
+	server           = "Linux/3.14.0 UPnP/1.0 IpBridge/1.26.0"
+)
+
+// Search is a parsed M-SEARCH request.
+type Search struct {
+	ST string
+	// MX is the number of seconds responses are spread over, zero for a unicast search.
+	MX int
+}
+
+// ParseSearch parses an M-SEARCH request, an error is returned for any other packet and for
+// searches without a quoted "ssdp:discover" MAN header, a search target or a valid MX.
+func ParseSearch(packet string) (*Search, error) {
+	reader := bufio.NewReader(strings.NewReader(packet))
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("ssdp packet has no start line")
+	}
+	if strings.TrimSpace(line) != "M-SEARCH * HTTP/1.1" {
+		return nil, fmt.Errorf("ssdp packet is not a search, %q", strings.TrimSpace(line))
+	}
+
+	headers := http.Header{}
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line != "" {
+			if i := strings.Index(line, ":"); i > 0 {
+				headers.Add(strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]))
+			}
+		}
+		if err != nil || line == "" {
+			break
+		}
+	}
+
+	// the upnp device architecture requires the quotes around ssdp:discover
+	if headers.Get("MAN") != `"ssdp:discover"` {
+		return nil, fmt.Errorf("ssdp search MAN is %q, not \"ssdp:discover\"", headers.Get("MAN"))
+	}
+	s := &Search{ST: headers.Get("ST")}
+	if s.ST == "" {
+		return nil, fmt.Errorf("ssdp search has no ST")
+	}
+	if mx := headers.Get("MX"); mx != "" {
+		if s.MX, err = strconv.Atoi(mx); err != nil || s.MX < 0 {
+			return nil, fmt.Errorf("ssdp search MX %q is invalid", mx)
+		}
+		if s.MX > MaxMX {
+			s.MX = MaxMX
+		}
+	}
+	return s, nil
+}
+
+// Delay is a random delay within mx seconds, spreading the responses to a search.
+func Delay(mx int) time.Duration {
+	if mx <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(time.Duration(mx) * time.Second)))
+}
+
+// Device is an upnp root device, identified by the uuid of its UDN.
+type Device struct {
+	UUID     string
+	Location string
+	BridgeID string
+}
+
+// Target is a notification type with its unique service name.
+type Target struct {
+	NT  string
+	USN string
+}
+
+// Targets are the root device, uuid and device type targets the device is announced as.
+func (d *Device) Targets() []Target {
+	uuid := "uuid:" + d.UUID
+	return []Target{
+		{NT: RootDevice, USN: uuid + "::" + RootDevice},
+		{NT: uuid, USN: uuid},
+		{NT: DeviceType, USN: uuid + "::" + DeviceType},
+	}
+}
+
+// Matching returns the targets a search for st is answered with, every target for ssdp:all.
+func (d *Device) Matching(st string) (targets []Target) {
+	for _, t := range d.Targets() {
+		if st == All || strings.EqualFold(st, t.NT) {
+			targets = append(targets, t)
+		}
+	}
+	return
+}
+
+// Response is the answer to a search for the target.
+func (d *Device) Response(t Target) []byte {
+	return message("HTTP/1.1 200 OK",
+		"CACHE-CONTROL", fmt.Sprintf("max-age=%d", MaxAge),
+		"DATE", time.Now().UTC().Format(http.TimeFormat),
+		"EXT", "",
+		"LOCATION", d.Location,
+		"SERVER", server,
+		"hue-bridgeid", d.BridgeID,
+		"ST", t.NT,
+		"USN", t.USN,
+	)
+}
+
+// Notify is the alive or byebye announcement of the target, multicast to host.
+func (d *Device) Notify(host string, t Target, nts string) []byte {
+	if nts == ByeBye {
+		return message("NOTIFY * HTTP/1.1",
+			"HOST", host,
+			"NT", t.NT,
+			"NTS", nts,
+			"USN", t.USN,
+		)
+	}
+	return message("NOTIFY * HTTP/1.1",
+		"HOST", host,
+		"CACHE-CONTROL", fmt.Sprintf("max-age=%d", MaxAge),
+		"LOCATION", d.Location,
+		"SERVER", server,
+		"hue-bridgeid", d.BridgeID,
+		"NT", t.NT,
+		"NTS", nts,
+		"USN", t.USN,
+	)
+}
+
+// message joins the start line and header name value pairs with the CRLF line endings ssdp requires.
+func message(startLine string, headers ...string) []byte {
+	b := &strings.Builder{}
+	b.WriteString(startLine + "\r\n")
+	for i := 0; i+1 < len(headers); i += 2 {
+		b.WriteString(headers[i] + ": " + headers[i+1] + "\r\n")
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
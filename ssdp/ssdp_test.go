@@ -0,0 +1,121 @@
+package ssdp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// search builds an M-SEARCH packet from header lines.
+func search(headers ...string) string {
+	return "M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\n" + strings.Join(headers, "\r\n") + "\r\n\r\n"
+}
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet string
+		st     string
+		mx     int
+	}{
+		{"valid", search(`MAN: "ssdp:discover"`, "MX: 3", "ST: ssdp:all"), All, 3},
+		{"unicast without MX", search(`MAN: "ssdp:discover"`, "ST: upnp:rootdevice"), RootDevice, 0},
+		{"lower case headers", search(`man: "ssdp:discover"`, "mx: 2", "st: upnp:rootdevice"), RootDevice, 2},
+		{"lf line endings", "M-SEARCH * HTTP/1.1\nMAN: \"ssdp:discover\"\nMX: 1\nST: ssdp:all\n\n", All, 1},
+		{"MX capped", search(`MAN: "ssdp:discover"`, "MX: 120", "ST: ssdp:all"), All, MaxMX},
+		{"MX zero", search(`MAN: "ssdp:discover"`, "MX: 0", "ST: ssdp:all"), All, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSearch(tt.packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.ST != tt.st || s.MX != tt.mx {
+				t.Errorf("search = %+v, want ST %s MX %d", s, tt.st, tt.mx)
+			}
+		})
+	}
+}
+
+func TestParseSearchInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet string
+		want   string
+	}{
+		{"empty", "", "no start line"},
+		{"notify", "NOTIFY * HTTP/1.1\r\nNT: upnp:rootdevice\r\n\r\n", "not a search"},
+		{"missing MAN", search("MX: 3", "ST: ssdp:all"), "MAN"},
+		{"unquoted MAN", search("MAN: ssdp:discover", "MX: 3", "ST: ssdp:all"), "MAN"},
+		{"other MAN", search(`MAN: "ssdp:update"`, "MX: 3", "ST: ssdp:all"), "MAN"},
+		{"missing ST", search(`MAN: "ssdp:discover"`, "MX: 3"), "no ST"},
+		{"empty ST", search(`MAN: "ssdp:discover"`, "MX: 3", "ST: "), "no ST"},
+		{"MX not a number", search(`MAN: "ssdp:discover"`, "MX: soon", "ST: ssdp:all"), "MX"},
+		{"MX negative", search(`MAN: "ssdp:discover"`, "MX: -1", "ST: ssdp:all"), "MX"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSearch(tt.packet); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelay(t *testing.T) {
+	if d := Delay(0); d != 0 {
+		t.Errorf("Delay(0) = %s, want 0", d)
+	}
+	for i := 0; i < 100; i++ {
+		if d := Delay(2); d < 0 || d >= 2*time.Second {
+			t.Fatalf("Delay(2) = %s, want within 2s", d)
+		}
+	}
+}
+
+func TestMatching(t *testing.T) {
+	d := &Device{UUID: "2f402f80-da50-11e1-9b23-001788255acc", Location: "http://10.0.0.2:80/description.xml", BridgeID: "001788FFFE255ACC"}
+	uuid := "uuid:" + d.UUID
+
+	tests := []struct {
+		st   string
+		want []string
+	}{
+		{All, []string{RootDevice, uuid, DeviceType}},
+		{RootDevice, []string{RootDevice}},
+		{uuid, []string{uuid}},
+		{strings.ToUpper(uuid), []string{uuid}},
+		{DeviceType, []string{DeviceType}},
+		{"urn:schemas-upnp-org:device:MediaRenderer:1", nil},
+		{"uuid:00000000-0000-0000-0000-000000000000", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.st, func(t *testing.T) {
+			targets := d.Matching(tt.st)
+			var nts []string
+			for _, target := range targets {
+				nts = append(nts, target.NT)
+			}
+			if strings.Join(nts, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Matching(%s) = %v, want %v", tt.st, nts, tt.want)
+			}
+		})
+	}
+
+	response := string(d.Response(d.Matching(RootDevice)[0]))
+	for _, header := range []string{
+		"HTTP/1.1 200 OK\r\n",
+		"LOCATION: " + d.Location + "\r\n",
+		"ST: upnp:rootdevice\r\n",
+		"USN: " + uuid + "::upnp:rootdevice\r\n",
+		"hue-bridgeid: " + d.BridgeID + "\r\n",
+	} {
+		if !strings.Contains(response, header) {
+			t.Errorf("response has no %q:\n%s", header, response)
+		}
+	}
+	if !strings.HasSuffix(response, "\r\n\r\n") {
+		t.Error("response does not end with an empty line")
+	}
+}
@@ -47,6 +47,8 @@ vhugo.config(['$routeProvider', function ($rp) {
 
 vhugo.controller('HomeController', function ($scope, $http, $mdDialog, $websocket) {
     $scope.lights = [];
+    $scope.lightTypes = ['Extended color light', 'Color temperature light', 'Dimmable light', 'On/Off plug-in unit'];
+    $scope.newLightType = $scope.lightTypes[0];
 
     // TODO: move to factory
     var loc = window.location, new_uri;
@@ -82,10 +84,17 @@ vhugo.controller('HomeController', function ($scope, $http, $mdDialog, $websocke
             if (d.lightID !== value.light_id) {
                 return
             }
-            if (d.stateRequest.on !== null) {
+            if (d.name !== undefined) {
+                value.name = d.name;
+            }
+            if (d.stateRequest == null) {
+                return
+            }
+            // state reports from devices may leave out the attributes that did not change
+            if (d.stateRequest.on != null) {
                 value.on = d.stateRequest.on;
             }
-            if (d.stateRequest.bri !== null) {
+            if (d.stateRequest.bri != null) {
                 value.brightness = d.stateRequest.bri;
             }
         });
@@ -120,14 +129,96 @@ vhugo.controller('HomeController', function ($scope, $http, $mdDialog, $websocke
 
         $mdDialog.show(confirm).then(function (result) {
             console.log("confirm " + result);
-            $http.post('/api/lights', {"name": result}).success(function (data) {
+            $http.post('/api/lights', {"name": result, "type": $scope.newLightType}).success(function (data) {
                 $scope.queryLights();
+                $scope.queryGroups();
+            }).error(function (data) {
+                $scope.groupError(ev, data);
             });
         }, function () {
             console.log("addLight cancel");
         });
     };
 
+    $scope.renameLight = function (ev, light) {
+        var confirm = $mdDialog.prompt()
+            .title('Rename ' + light.name)
+            .textContent('What is the new name of the light?')
+            .placeholder('Name')
+            .ariaLabel('Name')
+            .initialValue(light.name)
+            .targetEvent(ev)
+            .ok('Ok')
+            .cancel('cancel');
+
+        $mdDialog.show(confirm).then(function (result) {
+            var lurl = '/api/lights/' + light.group_id + '/' + light.light_id;
+            $http.put(lurl, {"name": result});
+        }, function () {
+            console.log("renameLight cancel");
+        });
+    };
+
+    $scope.moveLight = function (ev, light) {
+        var others = ($scope.groups || []).filter(function (g) {
+            return g.group_id !== light.group_id;
+        }).map(function (g) {
+            return g.group_id;
+        });
+        var confirm = $mdDialog.prompt()
+            .title('Move ' + light.name)
+            .textContent('Which device group should the light move to? ' + others.join(', '))
+            .placeholder('Device group')
+            .ariaLabel('Device group')
+            .initialValue(others.length ? others[0] : '')
+            .targetEvent(ev)
+            .ok('Ok')
+            .cancel('cancel');
+
+        $mdDialog.show(confirm).then(function (result) {
+            var lurl = '/api/lights/' + light.group_id + '/' + light.light_id + '/move';
+            $http.post(lurl, {"group": result}).success(function (data) {
+                $scope.queryLights();
+                $scope.queryGroups();
+            }).error(function (data) {
+                $scope.groupError(ev, data);
+            });
+        }, function () {
+            console.log("moveLight cancel");
+        });
+    };
+
+    $scope.showDeliveries = function (ev, light) {
+        var lurl = '/api/lights/' + light.group_id + '/' + light.light_id + '/deliveries';
+        $http.get(lurl).success(function (data) {
+            $mdDialog.show({
+                targetEvent: ev,
+                clickOutsideToClose: true,
+                locals: {light: light, deliveries: data || []},
+                controller: function ($scope, $mdDialog, light, deliveries) {
+                    $scope.light = light;
+                    $scope.deliveries = deliveries;
+                    $scope.close = function () {
+                        $mdDialog.hide();
+                    };
+                },
+                template: '<md-dialog aria-label="deliveries">' +
+                '<md-dialog-content class="md-dialog-content">' +
+                '<h2 class="md-title">{{light.name}} deliveries</h2>' +
+                '<p ng-if="!deliveries.length">No deliveries yet.</p>' +
+                '<div ng-repeat="d in deliveries">' +
+                '<b>{{d.timestamp}}</b> {{d.action}} {{d.target}} ' +
+                'status {{d.status}} attempts {{d.attempts}} {{d.duration}}ms ' +
+                '<span ng-if="d.error" class="md-warn">{{d.error}}</span>' +
+                '<pre ng-if="d.output">{{d.output}}</pre>' +
+                '</div>' +
+                '</md-dialog-content>' +
+                '<md-dialog-actions><md-button ng-click="close()">Close</md-button></md-dialog-actions>' +
+                '</md-dialog>'
+            });
+        });
+    };
+
     $scope.deleteLight = function (ev, light) {
         var confirm = $mdDialog.confirm()
             .title('Would you like to delete ' + light.name + '?')
@@ -147,6 +238,61 @@ vhugo.controller('HomeController', function ($scope, $http, $mdDialog, $websocke
         });
     };
 
+    $scope.queryGroups = function () {
+        $http.get('/api/groups').success(function (data) {
+            $scope.groups = data;
+        });
+    };
+
+    $scope.groupError = function (ev, data) {
+        $mdDialog.show($mdDialog.alert()
+            .title('Device group')
+            .textContent(data && data.error ? data.error : 'The request failed.')
+            .ariaLabel('Device group error')
+            .targetEvent(ev)
+            .ok('Ok'));
+    };
+
+    $scope.addGroup = function (ev) {
+        $http.post('/api/groups', {}).success(function (data) {
+            $scope.queryGroups();
+        }).error(function (data) {
+            $scope.groupError(ev, data);
+        });
+    };
+
+    $scope.deleteGroup = function (ev, group) {
+        var confirm = $mdDialog.confirm()
+            .title('Would you like to delete ' + group.name + '?')
+            .textContent('Hue apps paired with this bridge will lose it.')
+            .ariaLabel('Delete device group')
+            .targetEvent(ev)
+            .ok('Ok')
+            .cancel('Cancel');
+
+        $mdDialog.show(confirm).then(function () {
+            $http.delete('/api/groups/' + group.group_id).success(function (data) {
+                $scope.queryGroups();
+            }).error(function (data) {
+                $scope.groupError(ev, data);
+            });
+        }, function () {
+            console.log("deleteGroup cancel");
+        });
+    };
+
+    $scope.pressLinkButton = function (ev) {
+        $http.post('/api/linkbutton', {}).success(function (data) {
+            $mdDialog.show($mdDialog.alert()
+                .title('Link button pressed')
+                .textContent('Hue apps can register with vHuGo for the next 30 seconds.')
+                .ariaLabel('Link button pressed')
+                .targetEvent(ev)
+                .ok('Ok'));
+        });
+    };
+
     $scope.queryLights();
+    $scope.queryGroups();
 
 });
\ No newline at end of file
@@ -3,7 +3,10 @@ package main
 import (
 	"log"
 	"net"
+	"os"
 	"strings"
+
+	"github.com/mlctrez/vhugo/network"
 )
 
 func main() {
@@ -13,28 +16,13 @@ func main() {
 		panic(err)
 	}
 
-	var myIface *net.Interface
-
-	//interfaces, err := net.Interfaces()
-	//if err != nil {
-	//	panic(err)
-	//}
-	//
-	//for _, i := range interfaces {
-	//	addrs, err := i.Addrs()
-	//	if err != nil {
-	//		continue
-	//	}
-	//
-	//	for _, a := range addrs {
-	//		fmt.Println(i, a)
-	//	}
-	//
-	//	if i.Name == "eth1" {
-	//		fmt.Println("using ", i)
-	//		myIface = &i
-	//	}
-	//}
+	// INTERFACE names the interface to listen on, otherwise the first usable one is used
+	interfaces, err := network.Select(os.Getenv("INTERFACE"), "")
+	if err != nil {
+		panic(err)
+	}
+	myIface := interfaces[0].Interface
+	log.Println("using", myIface.Name, interfaces[0].IP())
 
 	var conn *net.UDPConn
 	if conn, err = net.ListenMulticastUDP("udp4", myIface, addr); err != nil {
@@ -12,11 +12,15 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/mlctrez/vhugo/command"
 	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/vhugo/devicegroups"
 	"github.com/mlctrez/vhugo/hlog"
+	"github.com/mlctrez/vhugo/lightstate"
 	"github.com/mlctrez/vhugo/natsserver"
 	"github.com/mlctrez/vhugo/static"
 	"github.com/mlctrez/vhugo/tlsconfig"
+	"github.com/mlctrez/vhugo/webhook"
 	web "github.com/mlctrez/web"
 )
 
@@ -27,6 +31,9 @@ type WebApp struct {
 	cancel        func()
 	DB            *devicedb.DeviceDB
 	Nats          *natsserver.NatsServer
+	Lights        *lightstate.Service
+	Groups        *devicegroups.Manager
+	Commands      *command.Runner
 	upgrader      websocket.Upgrader
 	tlsHost       string
 }
@@ -35,10 +42,14 @@ type WebContext struct {
 	App *WebApp
 }
 
-func New(db *devicedb.DeviceDB, nats *natsserver.NatsServer, logger *log.Logger, tlsHostName string) *WebApp {
+func New(db *devicedb.DeviceDB, nats *natsserver.NatsServer, lights *lightstate.Service, groups *devicegroups.Manager,
+	commands *command.Runner, logger *log.Logger, tlsHostName string) *WebApp {
 	return &WebApp{
 		DB:       db,
 		Nats:     nats,
+		Lights:   lights,
+		Groups:   groups,
+		Commands: commands,
 		logger:   hlog.New(logger, "WebApp"),
 		upgrader: websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024},
 		tlsHost:  tlsHostName,
@@ -54,6 +65,7 @@ type Light struct {
 	GroupID    string `json:"group_id"`
 	LightID    string `json:"light_id"`
 	Name       string `json:"name"`
+	Type       string `json:"type"`
 	On         bool   `json:"on"`
 	Brightness int32  `json:"brightness"`
 }
@@ -80,11 +92,12 @@ func (w *WebContext) Lights(rw web.ResponseWriter, req *web.Request) {
 			rw.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		for _, l := range lights {
+		for lightID, l := range lights {
 			lr.Lights = append(lr.Lights, Light{
 				GroupID:    dg.GroupID,
-				LightID:    devicedb.Sha(l.Name),
+				LightID:    lightID,
 				Name:       l.Name,
+				Type:       l.Type,
 				On:         l.State.On,
 				Brightness: l.State.Bri,
 			})
@@ -94,8 +107,12 @@ func (w *WebContext) Lights(rw web.ResponseWriter, req *web.Request) {
 	json.NewEncoder(rw).Encode(lr)
 }
 
+// AddLightRequest names the new light and picks its hue light type, an empty type is an extended color light.
+// Group pins the light to a device group, otherwise the placement strategy picks one.
 type AddLightRequest struct {
-	Name string
+	Name  string
+	Type  string
+	Group string
 }
 
 func (w *WebContext) AddLight(rw web.ResponseWriter, req *web.Request) {
@@ -105,39 +122,166 @@ func (w *WebContext) AddLight(rw web.ResponseWriter, req *web.Request) {
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if al.Type == "" {
+		al.Type = devicedb.ExtendedColorLight
+	}
+	if devicedb.GetLightProfile(al.Type) == nil || !devicedb.ValidLightName(al.Name) {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	l, err := w.App.addLight(al.Name, al.Type, al.Group)
+	if err != nil {
+		w.App.deviceGroupError(rw, err)
+		return
+	}
+	json.NewEncoder(rw).Encode(l)
+}
 
-	deviceGroups, err := w.App.DB.GetDeviceGroups()
+// addLight places a new light in groupID, or the device group the placement strategy picks when empty.
+func (w *WebApp) addLight(name string, lightType string, groupID string) (l *Light, err error) {
+	vl := devicedb.NewVirtualLight(name, lightType)
+	l = &Light{Name: vl.Name, Type: vl.Type, On: vl.State.On, Brightness: vl.State.Bri}
+	if l.GroupID, l.LightID, err = w.Groups.AddLight(vl, groupID); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// MoveLightRequest names the device group a light moves to.
+type MoveLightRequest struct {
+	Group string `json:"group"`
+}
+
+// MoveLight moves a light to another device group, the light gets a new light id there.
+func (w *WebContext) MoveLight(rw web.ResponseWriter, req *web.Request) {
+	groupID := req.PathParams["groupID"]
+	lightID := req.PathParams["lightID"]
+	mr := &MoveLightRequest{}
+	if err := json.NewDecoder(req.Body).Decode(mr); err != nil || mr.Group == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	newLightID, err := w.App.Groups.MoveLight(groupID, lightID, mr.Group)
+	if err != nil {
+		w.App.deviceGroupError(rw, err)
+		return
+	}
+	vl, err := w.App.DB.GetVirtualLight(mr.Group, newLightID)
 	if err != nil {
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	added := false
+	json.NewEncoder(rw).Encode(&Light{
+		GroupID:    mr.Group,
+		LightID:    newLightID,
+		Name:       vl.Name,
+		Type:       vl.Type,
+		On:         vl.State.On,
+		Brightness: vl.State.Bri,
+	})
+}
+
+// LightAnnouncement is published on the lightAnnounce subject by a backend that drives a light.
+// A light with the name is added unless one exists, the reply is the Light the name refers to.
+type LightAnnouncement struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// announceLight adds announced lights so they are found by the next search for new lights.
+func (w *WebApp) announceLight(subject, reply string, la *LightAnnouncement) {
+	if la.Type == "" {
+		la.Type = devicedb.ExtendedColorLight
+	}
+	if devicedb.GetLightProfile(la.Type) == nil || !devicedb.ValidLightName(la.Name) {
+		w.logger.Println("invalid light announcement", la.Name, la.Type)
+		return
+	}
+	l, err := w.findLight(la.Name)
+	if err != nil {
+		w.logger.Println("findLight", la.Name, err)
+		return
+	}
+	if l == nil {
+		if l, err = w.addLight(la.Name, la.Type, ""); err != nil {
+			w.logger.Println("addLight", la.Name, err)
+			return
+		}
+		w.logger.Println("added announced light", l.GroupID, l.LightID, l.Name)
+	}
+	if reply != "" {
+		w.Nats.Publish(reply, l)
+	}
+}
+
+// findLight returns the light with the name in any device group, nil when there is none.
+func (w *WebApp) findLight(name string) (*Light, error) {
+	deviceGroups, err := w.DB.GetDeviceGroups()
+	if err != nil {
+		return nil, err
+	}
 	for _, dg := range deviceGroups {
-		lights, err := w.App.DB.GetVirtualLights(dg.GroupID)
+		lights, err := w.DB.GetVirtualLights(dg.GroupID)
 		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			return
+			return nil, err
 		}
-		if len(lights) < 50 {
-			light := devicedb.NewVirtualLight(al.Name)
-			err = w.App.DB.UpdateVirtualLight(dg.GroupID, light)
-			if err != nil {
-				rw.WriteHeader(http.StatusInternalServerError)
-				return
+		for lightID, l := range lights {
+			if l.Name == name {
+				return &Light{
+					GroupID:    dg.GroupID,
+					LightID:    lightID,
+					Name:       l.Name,
+					Type:       l.Type,
+					On:         l.State.On,
+					Brightness: l.State.Bri,
+				}, nil
 			}
-			added = true
-			return
 		}
 	}
-	if !added {
+	return nil, nil
+}
+
+type UpdateLightRequest struct {
+	Name string `json:"name"`
+}
+
+// UpdateLight renames a light, the light keeps its id and state.
+func (w *WebContext) UpdateLight(rw web.ResponseWriter, req *web.Request) {
+	groupID := req.PathParams["groupID"]
+	lightID := req.PathParams["lightID"]
+	ul := &UpdateLightRequest{}
+	err := json.NewDecoder(req.Body).Decode(ul)
+	if err != nil || !devicedb.ValidLightName(ul.Name) {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	virtualLight, err := w.App.Lights.Edit(groupID, lightID, func(vl *devicedb.VirtualLight) error {
+		vl.Name = ul.Name
+		return nil
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "does not exist") {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
 		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	l := &Light{
+		Name:       virtualLight.Name,
+		GroupID:    groupID,
+		LightID:    lightID,
+		Type:       virtualLight.Type,
+		On:         virtualLight.State.On,
+		Brightness: virtualLight.State.Bri,
 	}
+	json.NewEncoder(rw).Encode(l)
 }
 
 func (w *WebContext) DeleteLight(rw web.ResponseWriter, req *web.Request) {
 	groupID := req.PathParams["groupID"]
 	lightID := req.PathParams["lightID"]
-	err := w.App.DB.DeleteVirtualLight(groupID, lightID)
+	err := w.App.Lights.Delete(groupID, lightID)
 	if err != nil {
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
@@ -153,33 +297,171 @@ func (w *WebContext) ChangeState(rw web.ResponseWriter, req *web.Request) {
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	virtualLight, err := w.App.DB.GetVirtualLight(groupID, lightID)
+	origin := lightstate.Origin{Source: lightstate.SourceWebUI, RemoteAddr: req.RemoteAddr}
+	virtualLight, err := w.App.Lights.Change(groupID, lightID, sr, origin)
+	if err != nil {
+		w.App.logger.Println("Lights.Change", groupID, lightID, err)
+		switch {
+		case strings.Contains(err.Error(), "does not exist"):
+			rw.WriteHeader(http.StatusNotFound)
+		case strings.Contains(err.Error(), "unreachable"):
+			rw.WriteHeader(http.StatusGatewayTimeout)
+		default:
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	l := &Light{
+		Name:       virtualLight.Name,
+		GroupID:    groupID,
+		LightID:    lightID,
+		Type:       virtualLight.Type,
+		On:         virtualLight.State.On,
+		Brightness: virtualLight.State.Bri,
+	}
+	json.NewEncoder(rw).Encode(l)
+}
+
+// LightOptions returns the vhugo options of a light, such as acknowledged state changes.
+func (w *WebContext) LightOptions(rw web.ResponseWriter, req *web.Request) {
+	groupID := req.PathParams["groupID"]
+	lightID := req.PathParams["lightID"]
+	if _, err := w.App.DB.GetVirtualLight(groupID, lightID); err != nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	lo, err := w.App.DB.GetLightOptions(groupID, lightID)
 	if err != nil {
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	virtualLight.UpdateState(sr)
+	json.NewEncoder(rw).Encode(lo)
+}
 
-	// TODO: consolidate the this function with apiserver.go:108
-	ch := make(map[string]interface{})
-	ch["groupID"] = groupID
-	ch["lightID"] = lightID
-	ch["stateRequest"] = sr
+func (w *WebContext) UpdateLightOptions(rw web.ResponseWriter, req *web.Request) {
+	groupID := req.PathParams["groupID"]
+	lightID := req.PathParams["lightID"]
+	lo := &devicedb.LightOptions{}
+	if err := json.NewDecoder(req.Body).Decode(lo); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, wh := range lo.Webhooks {
+		if err := webhook.Validate(wh); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(rw).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	if err := w.App.Commands.ValidateOptions(lo); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	if err := w.App.DB.UpdateLightOptions(groupID, lightID, lo); err != nil {
+		switch {
+		case strings.Contains(err.Error(), "does not exist"):
+			rw.WriteHeader(http.StatusNotFound)
+		case strings.Contains(err.Error(), "invalid"):
+			rw.WriteHeader(http.StatusBadRequest)
+		default:
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	json.NewEncoder(rw).Encode(lo)
+}
 
-	w.App.Nats.Publish("lightStateChange", ch)
+// Deliveries returns the most recent webhook and exec deliveries of a light, newest first.
+func (w *WebContext) Deliveries(rw web.ResponseWriter, req *web.Request) {
+	groupID := req.PathParams["groupID"]
+	lightID := req.PathParams["lightID"]
+	if _, err := w.App.DB.GetVirtualLight(groupID, lightID); err != nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	deliveries, err := w.App.DB.GetDeliveries(groupID, lightID)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(rw).Encode(deliveries)
+}
 
-	err = w.App.DB.UpdateVirtualLight(groupID, virtualLight)
+// DeviceGroups lists the device groups with the number of lights in each.
+func (w *WebContext) DeviceGroups(rw web.ResponseWriter, req *web.Request) {
+	groups, err := w.App.Groups.Groups()
 	if err != nil {
+		w.App.logger.Println("App.Groups.Groups()", err)
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	l := &Light{
-		Name:       virtualLight.Name,
-		GroupID:    groupID,
-		On:         virtualLight.State.On,
-		Brightness: virtualLight.State.Bri,
+	json.NewEncoder(rw).Encode(groups)
+}
+
+// AddDeviceGroup creates a device group and starts its api server.
+func (w *WebContext) AddDeviceGroup(rw web.ResponseWriter, req *web.Request) {
+	dg, err := w.App.Groups.Create()
+	if err != nil {
+		w.App.deviceGroupError(rw, err)
+		return
+	}
+	json.NewEncoder(rw).Encode(dg)
+}
+
+// ResizeDeviceGroupsRequest is the number of device groups wanted.
+type ResizeDeviceGroupsRequest struct {
+	Count int `json:"count"`
+}
+
+// ResizeDeviceGroups creates or deletes device groups until the requested number exists.
+func (w *WebContext) ResizeDeviceGroups(rw web.ResponseWriter, req *web.Request) {
+	rr := &ResizeDeviceGroupsRequest{}
+	if err := json.NewDecoder(req.Body).Decode(rr); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	groups, err := w.App.Groups.Resize(rr.Count)
+	if err != nil {
+		w.App.deviceGroupError(rw, err)
+		return
+	}
+	json.NewEncoder(rw).Encode(groups)
+}
+
+// DeleteDeviceGroup stops the api server of an empty device group and removes it.
+func (w *WebContext) DeleteDeviceGroup(rw web.ResponseWriter, req *web.Request) {
+	if err := w.App.Groups.Delete(req.PathParams["groupID"]); err != nil {
+		w.App.deviceGroupError(rw, err)
+	}
+}
+
+func (w *WebApp) deviceGroupError(rw web.ResponseWriter, err error) {
+	w.logger.Println("device group", err)
+	switch {
+	case strings.Contains(err.Error(), "does not exist"):
+		rw.WriteHeader(http.StatusNotFound)
+	case strings.Contains(err.Error(), "not empty"), strings.Contains(err.Error(), "last device group"),
+		strings.Contains(err.Error(), "at most"), strings.Contains(err.Error(), "full"),
+		strings.Contains(err.Error(), "already is in"):
+		rw.WriteHeader(http.StatusConflict)
+	case strings.Contains(err.Error(), "invalid"):
+		rw.WriteHeader(http.StatusBadRequest)
+	default:
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(rw).Encode(map[string]string{"error": err.Error()})
+}
+
+// LinkButton presses the virtual link button so hue apps can register a user.
+func (w *WebContext) LinkButton(rw web.ResponseWriter, req *web.Request) {
+	err := w.App.DB.PressLinkButton(30 * time.Second)
+	if err != nil {
+		w.App.logger.Println("App.DB.PressLinkButton()", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-	json.NewEncoder(rw).Encode(l)
 }
 
 type WsMessage struct {
@@ -218,7 +500,24 @@ func (w *WebApp) Run(addr string, ctx context.Context) {
 	router.Get("/api/lights", (*WebContext).Lights)
 	router.Post("/api/lights", (*WebContext).AddLight)
 	router.Post("/api/lights/:groupID/:lightID", (*WebContext).ChangeState)
+	router.Put("/api/lights/:groupID/:lightID", (*WebContext).UpdateLight)
 	router.Delete("/api/lights/:groupID/:lightID", (*WebContext).DeleteLight)
+	router.Get("/api/lights/:groupID/:lightID/options", (*WebContext).LightOptions)
+	router.Put("/api/lights/:groupID/:lightID/options", (*WebContext).UpdateLightOptions)
+	router.Get("/api/lights/:groupID/:lightID/deliveries", (*WebContext).Deliveries)
+	router.Post("/api/lights/:groupID/:lightID/move", (*WebContext).MoveLight)
+	router.Get("/api/groups", (*WebContext).DeviceGroups)
+	router.Post("/api/groups", (*WebContext).AddDeviceGroup)
+	router.Put("/api/groups", (*WebContext).ResizeDeviceGroups)
+	router.Delete("/api/groups/:groupID", (*WebContext).DeleteDeviceGroup)
+	router.Post("/api/linkbutton", (*WebContext).LinkButton)
+
+	announceSub, err := w.Nats.Subscribe("lightAnnounce", w.announceLight)
+	if err != nil {
+		w.logger.Println("Subscribe lightAnnounce", err)
+		return
+	}
+	defer announceSub.Unsubscribe()
 
 	server.Handler = router
 	go func() {
@@ -37,19 +37,24 @@ func (w *WebContext) OnConnected(ws *websocket.Conn) {
 		}
 	}()
 
-	subscription, err := app.Nats.Subscribe("lightStateChange", func(msg *nats.Msg) {
-		err := ws.WriteMessage(websocket.TextMessage, msg.Data)
+	// the subscriptions deliver on their own goroutines, a websocket allows a single writer
+	messages := make(chan []byte, 64)
+	forward := func(msg *nats.Msg) {
+		select {
+		case messages <- msg.Data:
+		case <-webSocketcontext.Done():
+		}
+	}
+	// device state reports arrive on lightStateChange once stored
+	for _, subject := range []string{"lightStateChange", "lightAttributeChange"} {
+		subscription, err := app.Nats.Subscribe(subject, forward)
 		if err != nil {
-			logger.Println("Messages error writing to client", address, err)
+			logger.Println("Nats.Subscribe", subject, err)
 			cancel()
+			return
 		}
-	})
-	if err != nil {
-		logger.Println("Nats.Subscribe", err)
-		cancel()
-		return
+		defer subscription.Unsubscribe()
 	}
-	defer subscription.Unsubscribe()
 
 	var running = true
 	go func() {
@@ -70,9 +75,19 @@ func (w *WebContext) OnConnected(ws *websocket.Conn) {
 			}
 		}
 	}()
-	<-webSocketcontext.Done()
-	running = false
-	logger.Println("OnConnected exit", address)
+	for {
+		select {
+		case data := <-messages:
+			if err := ws.WriteMessage(websocket.TextMessage, data); err != nil {
+				logger.Println("Messages error writing to client", address, err)
+				cancel()
+			}
+		case <-webSocketcontext.Done():
+			running = false
+			logger.Println("OnConnected exit", address)
+			return
+		}
+	}
 }
 
 func (w *WebContext) Messages(rw web.ResponseWriter, req *web.Request) {
@@ -0,0 +1,201 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mlctrez/vhugo/devicedb"
+	"github.com/mlctrez/vhugo/hlog"
+	"github.com/mlctrez/vhugo/lightstate"
+	"github.com/mlctrez/vhugo/natsserver"
+)
+
+// Defaults for webhooks that do not set a timeout, and the limits of the retries.
+const (
+	DefaultTimeout = 5 * time.Second
+	MaxRetries     = 5
+	retryBackoff   = 500 * time.Millisecond
+)
+
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Validate checks the method, templates and limits of a webhook before it is stored.
+func Validate(w *devicedb.Webhook) error {
+	switch strings.ToUpper(w.Method) {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return fmt.Errorf("invalid webhook method %s", w.Method)
+	}
+	if _, err := template.New("url").Funcs(funcs).Parse(w.URL); err != nil {
+		return fmt.Errorf("invalid webhook url template, %v", err)
+	}
+	if _, err := template.New("body").Funcs(funcs).Parse(w.Body); err != nil {
+		return fmt.Errorf("invalid webhook body template, %v", err)
+	}
+	if w.Timeout < 0 || w.Retries < 0 || w.Retries > MaxRetries {
+		return fmt.Errorf("invalid webhook timeout %d or retries %d", w.Timeout, w.Retries)
+	}
+	return nil
+}
+
+func render(name string, text string, event *lightstate.Event) (string, error) {
+	t, err := template.New(name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err = t.Execute(buf, event); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Dispatcher calls the webhooks of a light on each of its state changes and records the outcome
+// in the delivery log of the light.
+type Dispatcher struct {
+	DB     *devicedb.DeviceDB
+	NS     *natsserver.NatsServer
+	client *http.Client
+	logger *hlog.HLog
+}
+
+func New(db *devicedb.DeviceDB, ns *natsserver.NatsServer, logger *log.Logger) *Dispatcher {
+	return &Dispatcher{DB: db, NS: ns, client: &http.Client{}, logger: hlog.New(logger, "Webhooks")}
+}
+
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.logger.Println("Run() entry")
+
+	subscription, err := d.NS.Subscribe(lightstate.LightSubject("*", "*", lightstate.KindState),
+		func(subject, reply string, event *lightstate.Event) {
+			go d.dispatch(ctx, reply, event)
+		})
+	if err != nil {
+		d.logger.Println("Subscribe", err)
+		return
+	}
+	defer subscription.Unsubscribe()
+
+	<-ctx.Done()
+	d.logger.Println("Run() exit")
+}
+
+// dispatch calls the webhooks of the light in order. When the light acknowledges state changes
+// the change is acknowledged after every webhook succeeded.
+func (d *Dispatcher) dispatch(ctx context.Context, reply string, event *lightstate.Event) {
+	lo, err := d.DB.GetLightOptions(event.GroupID, event.LightID)
+	if err != nil {
+		d.logger.Println("GetLightOptions", event.GroupID, event.LightID, err)
+		return
+	}
+	if len(lo.Webhooks) == 0 {
+		return
+	}
+	succeeded := true
+	for _, w := range lo.Webhooks {
+		delivery := d.call(ctx, w, event)
+		if delivery.Error != "" {
+			succeeded = false
+			d.logger.Println("webhook", event.GroupID, event.LightID, delivery.Target, delivery.Error)
+		}
+		if err = d.DB.AddDelivery(event.GroupID, event.LightID, delivery); err != nil {
+			d.logger.Println("AddDelivery", event.GroupID, event.LightID, err)
+		}
+	}
+	if succeeded && reply != "" {
+		d.NS.Publish(reply, map[string]string{"dispatcher": "webhook"})
+	}
+}
+
+// call makes the webhook request, retrying failed attempts with an increasing delay.
+func (d *Dispatcher) call(ctx context.Context, w *devicedb.Webhook, event *lightstate.Event) *devicedb.Delivery {
+	start := time.Now()
+	method := strings.ToUpper(w.Method)
+	delivery := &devicedb.Delivery{
+		Timestamp: start.UTC().Format(devicedb.HueTime),
+		Action:    "webhook",
+		Target:    method + " " + w.URL,
+	}
+	defer func() {
+		delivery.Duration = time.Since(start).Milliseconds()
+	}()
+
+	url, err := render("url", w.URL, event)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	delivery.Target = method + " " + url
+	body, err := render("body", w.Body, event)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	timeout := DefaultTimeout
+	if w.Timeout > 0 {
+		timeout = time.Duration(w.Timeout) * time.Millisecond
+	}
+
+	for attempt := 0; attempt <= w.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return delivery
+			case <-time.After(time.Duration(attempt) * retryBackoff):
+			}
+		}
+		delivery.Attempts++
+		delivery.Status, err = d.attempt(ctx, method, url, body, w.Headers, timeout)
+		if err == nil {
+			delivery.Error = ""
+			return delivery
+		}
+		delivery.Error = err.Error()
+	}
+	return delivery
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, method, url, body string, headers map[string]string, timeout time.Duration) (int, error) {
+	attemptContext, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var bodyReader io.Reader
+	if body != "" {
+		bodyReader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, bodyReader)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(attemptContext)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return res.StatusCode, nil
+}